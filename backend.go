@@ -0,0 +1,80 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+//backend is an output format. render walks the tree of a page and says
+//what goes on it through this, in the order it goes there, and the backend
+//decides how that is written.
+type backend interface {
+	begin(name, sec, date, version, manual string) //before anything else
+	finish()                                       //after everything else
+	name_line(name string, descr []byte)           //the NAME section
+	section(name string)
+	subsection(name string)
+	anchor(id string) //marks where an identifier is declared
+	PP()              //a new paragraph, which ends a tag
+	text(p []byte)
+	paras(ps []interface{}) //see section
+	line(s ...span)         //one line of a synopsis or declaration
+	tag(s ...span)          //what the text up to the next tag or PP is about
+	indent()
+	outdent()
+	see_also(refs []string)
+	Bytes() []byte
+}
+
+//a span is a piece of a line, marked with what it is so each backend can
+//set it apart in its own way.
+type span struct {
+	kind int
+	text string
+	link string //the anchor of what it names, or ""
+}
+
+const (
+	sp_text  = iota //punctuation and plain words
+	sp_name         //the command, or what a line declares or lists
+	sp_flag         //a switch like -v
+	sp_arg          //what stands for an argument
+	sp_go           //Go source
+	sp_param        //the name of a parameter
+	sp_ref          //a reference to a man page, like ls(1)
+)
+
+//format is an output format the -format flag can select, and the extension
+//its files get with -o.
+type format struct {
+	ext string
+	new func(o *options) writer
+}
+
+var formats = map[string]format{
+	"man":      {"", func(o *options) writer { return rendered{Formatter()} }},
+	"html":     {".html", func(o *options) writer { return rendered{HTML()} }},
+	"markdown": {".md", func(o *options) writer { return rendered{Markdown()} }},
+	"mdoc":     {"", func(o *options) writer { return rendered{Mdoc()} }},
+	"text":     {".txt", func(o *options) writer { return rendered{Plain(o.width, o.ansi)} }},
+	"json":     {".json", func(o *options) writer { return JSON() }},
+}
+
+//format_names lists the formats for the message about one that is not.
+func format_names() string {
+	var out []string
+	for n := range formats {
+		out = append(out, n)
+	}
+	sort.Strings(out)
+	return strings.Join(out, ", ")
+}
+
+//split_ref breaks a reference like ls(1) into the name and the section.
+func split_ref(s string) (name, sec string) {
+	piv := strings.Index(s, "(")
+	if piv == -1 {
+		return s, ""
+	}
+	return s[:piv], strings.Trim(s[piv:], "()")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/tools/go/packages"
+)
+
+//batch writes a page for each package into dir, named name.section, or
+//manN/name.section with mandirs, and reports what it did on stderr.
+func batch(fs *token.FileSet, pkgs []*packages.Package, o *options, dir string) {
+	single_flags(pkgs, o)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		fatal(err)
+	}
+
+	written := map[string]string{} //file name -> import path
+	count := map[string]int{}      //section -> pages
+	subs := 0
+	for _, p := range with_files(pkgs) {
+		m := page(fs, p, o)
+		for i, pg := range append([]*M{m}, m.subs...) {
+			file := page_file(pg, o)
+			if prev, ok := written[file]; ok {
+				stderr("skipping " + p.PkgPath + ": " + file + " already written for " + prev)
+				continue
+			}
+			written[file] = p.PkgPath
+			path := filepath.Join(dir, file)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				fatal(err)
+			}
+			if err := ioutil.WriteFile(path, page_bytes(pg, o), 0644); err != nil {
+				fatal(err)
+			}
+			if i == 0 {
+				count[pg.sec]++
+			} else {
+				subs++
+			}
+		}
+	}
+
+	stderr(fmt.Sprintf("wrote %d pages to %s: %d commands, %d subcommands, %d packages",
+		len(written), dir, count["1"], subs, count["3"]))
+}
+
+//with_files are the packages of pkgs with files other than tests, as one of
+//only _test.go files has nothing to make a page of.
+func with_files(pkgs []*packages.Package) (out []*packages.Package) {
+	for _, p := range pkgs {
+		if len(p.GoFiles) > 0 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+//single_flags stops when flags that only make sense for one package are
+//used with several.
+func single_flags(pkgs []*packages.Package, o *options) {
+	if len(pkgs) > 1 {
+		if o.name != "" || o.import_path != "" || o.version != "" || len(o.overd) > 0 {
+			fatal("The name, import, version, section and include flags apply to a single package.")
+		}
+	}
+}
+
+//page_file is where in the directory given to -o a page goes.
+func page_file(pg *M, o *options) string {
+	file := pg.name + "." + pg.sec + formats[o.format].ext
+	if o.mandirs {
+		file = filepath.Join("man"+pg.sec, file)
+	}
+	if o.gzip {
+		file += ".gz"
+	}
+	return file
+}
+
+//page_bytes is what is written for a page, compressed with -gzip.
+func page_bytes(pg *M, o *options) []byte {
+	if !o.gzip {
+		return pg.Bytes()
+	}
+	var buf bytes.Buffer
+	//no name or time in the header, so the same page compresses the same
+	z, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	z.Write(pg.Bytes())
+	if err := z.Close(); err != nil {
+		fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+//check_date stands in for the date while checking, so a page made on another
+//day is not stale for that alone. It is as long as a date, so the lines it
+//is in are laid out the same.
+const check_date = "0000-00-00"
+
+//check_dates are check_date as the pages may give it, which for the version
+//mdoc gives in .Os is escaped for troff.
+var check_dates = []string{check_date, string(escape([]byte(check_date)))}
+
+//datepat matches a date as the pages give it, as in 2006-01-02, escaped as in
+//2006\-01\-02, or, in mdoc, January 2, 2006.
+const datepat = `([0-9]{4}-[0-9]{2}-[0-9]{2}|[0-9]{4}\\-[0-9]{2}\\-[0-9]{2}|[A-Z][a-z]+ [0-9]{1,2}, [0-9]{4})`
+
+//check compares the pages for pkgs with those at path, which is the file of
+//a single page or a directory laid out as -o would lay it out.
+//It writes a diff for each page that is stale to stdout, and exits 1 if any
+//is stale or missing.
+func check(fs *token.FileSet, pkgs []*packages.Package, o *options, path string) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		fatal(err)
+	}
+	stale, n := 0, 0
+	if !fi.IsDir() {
+		n++
+		if !check_page(page(fs, pkgs[0], o).Bytes(), path) {
+			stale++
+		}
+	} else {
+		single_flags(pkgs, o)
+		for _, p := range with_files(pkgs) {
+			m := page(fs, p, o)
+			for _, pg := range append([]*M{m}, m.subs...) {
+				n++
+				if !check_page(pg.Bytes(), filepath.Join(path, page_file(pg, o))) {
+					stale++
+				}
+			}
+		}
+	}
+	if stale > 0 {
+		stderr(fmt.Sprintf("%d of %d pages are out of date", stale, n))
+		os.Exit(1)
+	}
+}
+
+//check_page reports whether the page in file is the page made, apart from
+//the date, and writes how they differ to stdout if it is not.
+func check_page(made []byte, file string) bool {
+	old, err := ioutil.ReadFile(file)
+	if err != nil {
+		stderr(err)
+		return false
+	}
+	if strings.HasSuffix(file, ".gz") {
+		z, err := gzip.NewReader(bytes.NewReader(old))
+		if err == nil {
+			old, err = ioutil.ReadAll(z)
+		}
+		if err != nil {
+			stderr(file + ": " + err.Error())
+			return false
+		}
+	}
+
+	a, b := split_lines(old), split_lines(made)
+	//the lines with the date read as they do in the file if they differ
+	//only in the date; those in the second half are found counting from the
+	//end, past what was added
+	for i, l := range b {
+		rx := regexp.QuoteMeta(l)
+		for _, d := range check_dates {
+			rx = strings.Replace(rx, regexp.QuoteMeta(d), datepat, -1)
+		}
+		if rx == regexp.QuoteMeta(l) {
+			continue
+		}
+		j := i
+		if i >= len(b)/2 {
+			j = len(a) - (len(b) - i)
+		}
+		if j < 0 || j >= len(a) {
+			continue
+		}
+		if RX("^" + rx + "$").MatchString(a[j]) {
+			b[i] = a[j]
+		}
+	}
+	if strings.Join(a, "") == strings.Join(b, "") {
+		return true
+	}
+	os.Stdout.WriteString(unified(file, file+" (generated)", a, b))
+	return false
+}
+
+//split_lines splits p into lines, each with its newline.
+func split_lines(p []byte) []string {
+	ls := strings.SplitAfter(string(p), "\n")
+	if ls[len(ls)-1] == "" {
+		ls = ls[:len(ls)-1]
+	}
+	return ls
+}
+
+//unified is a unified diff of the lines a and b, with three lines of
+//context around each change.
+func unified(an, bn string, a, b []string) string {
+	//lcs[i][j] is the length of the longest common subsequence of a[i:]
+	//and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	type op struct {
+		kind   byte //' ', '-' or '+'
+		line   string
+		ai, bi int //the lines of a and b before it
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			ops = append(ops, op{' ', a[i], i, j})
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', a[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j], i, j})
+			j++
+		}
+	}
+
+	const ctx = 3
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", an, bn)
+	for k := 0; k < len(ops); k++ {
+		if ops[k].kind == ' ' {
+			continue
+		}
+		//a hunk, from the context before this change to that after the last
+		//change close enough to be in it
+		start := k - ctx
+		if start < 0 {
+			start = 0
+		}
+		end := k
+		for e := k; e < len(ops) && e <= end+2*ctx; e++ {
+			if ops[e].kind != ' ' {
+				end = e
+			}
+		}
+		stop := end + ctx + 1
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+		al, bl := 0, 0
+		for _, o := range ops[start:stop] {
+			if o.kind != '+' {
+				al++
+			}
+			if o.kind != '-' {
+				bl++
+			}
+		}
+		as, bs := ops[start].ai, ops[start].bi
+		if al > 0 {
+			as++
+		}
+		if bl > 0 {
+			bs++
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", as, al, bs, bl)
+		for _, o := range ops[start:stop] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			if !strings.HasSuffix(o.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		k = stop - 1
+	}
+	return out.String()
+}
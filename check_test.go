@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+//made is a small page in the format f, with the version falling back to the
+//date as do_header has it.
+func made(f, date, version string) []byte {
+	if version == "" {
+		version = date
+	}
+	t := new_tree()
+	t.begin("fj", "3", date, version, "Go Packages")
+	t.name_line("fj", []byte("Package fj has values."))
+	t.section("DESCRIPTION")
+	t.text([]byte("Some text, made on some day."))
+	w := formats[f].new(&options{width: 80})
+	w.write(t.pg)
+	return w.Bytes()
+}
+
+//TestCheckPage writes a page as if made on one day, and checks it against
+//one made for checking, in every format.
+func TestCheckPage(t *testing.T) {
+	var fs []string
+	for f := range formats {
+		fs = append(fs, f)
+	}
+	sort.Strings(fs)
+	dir := t.TempDir()
+	for _, f := range fs {
+		for _, c := range []struct {
+			old, now string //versions
+			same     bool
+		}{
+			{"1.2", "1.2", true},
+			{"", "", true},
+			{"1.2", "1.3", false},
+			{"", "1.2", false},
+			{"1.2", "", false},
+		} {
+			file := filepath.Join(dir, "fj.3"+formats[f].ext)
+			if err := ioutil.WriteFile(file, made(f, "2026-10-16", c.old), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := check_page(made(f, check_date, c.now), file); got != c.same {
+				t.Errorf("%s: version %q checked against %q is the same: %v, want %v",
+					f, c.old, c.now, got, c.same)
+			}
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"go/ast"
+	"strings"
+)
+
+//cobra_cmd is a cobra.Command literal and what is attached to it.
+type cobra_cmd struct {
+	lit    *ast.CompositeLit
+	parent *cobra_cmd
+	kids   []*cobra_cmd
+	flags  []*opt
+}
+
+//cobra_use splits the Use line of a command into its name and the rest of
+//its usage, without the [flags] cobra adds on its own.
+func cobra_use(use string) (name, usage string) {
+	words := strings.Fields(use)
+	if len(words) == 0 {
+		return "", ""
+	}
+	var rest []string
+	for _, w := range words[1:] {
+		if w != "[flags]" {
+			rest = append(rest, w)
+		}
+	}
+	return words[0], strings.Join(rest, " ")
+}
+
+//grep_cobra is the extractor for github.com/spf13/cobra. It reads the
+//cobra.Command literals in the package, how they are put together with
+//AddCommand, and the flags defined on them with pflag.
+func grep_cobra(m *M, fl *flags) (descrs []string) {
+	var all []*cobra_cmd
+	cmds := map[*ast.CompositeLit]*cobra_cmd{}
+	for _, file := range files(m.pkg) {
+		r := grep_refs(file, "github.com/spf13/cobra")
+		if r.none() {
+			continue
+		}
+		ast.Inspect(file, func(n ast.Node) bool {
+			if cl, ok := n.(*ast.CompositeLit); ok {
+				if t, ok := r.is(cl.Type); ok && t == "Command" {
+					c := &cobra_cmd{lit: cl}
+					cmds[cl] = c
+					all = append(all, c)
+				}
+			}
+			return true
+		})
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	d := grep_defs(m.pkg, m.info)
+	get := func(x ast.Expr) *cobra_cmd {
+		if cl := d.composite(x); cl != nil {
+			return cmds[cl]
+		}
+		return nil
+	}
+	//X.Flags(), X.PersistentFlags(), or a name for either
+	owner := func(x ast.Expr) *cobra_cmd {
+		c, ok := d.resolve(x).(*ast.CallExpr)
+		if !ok {
+			return nil
+		}
+		if s, ok := c.Fun.(*ast.SelectorExpr); ok {
+			switch s.Sel.Name {
+			case "Flags", "PersistentFlags", "LocalFlags":
+				return get(s.X)
+			}
+		}
+		return nil
+	}
+	for _, file := range files(m.pkg) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			s, ok := call.Fun.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			if s.Sel.Name == "AddCommand" {
+				if p := get(s.X); p != nil {
+					for _, a := range call.Args {
+						if k := get(a); k != nil && k.parent == nil && k != p {
+							k.parent = p
+							p.kids = append(p.kids, k)
+						}
+					}
+				}
+			} else if c := owner(s.X); c != nil {
+				if o := pflag_opt(d, s.Sel.Name, call.Args); o != nil {
+					c.flags = append(c.flags, o)
+					descrs = append(descrs, string(o.help))
+				}
+			}
+			return true
+		})
+	}
+
+	//the root is the one named like the command, or failing that the one
+	//with the most under it; any others left over hang off of it
+	var root *cobra_cmd
+	size := func(c *cobra_cmd) int {
+		n := 0
+		var count func(*cobra_cmd)
+		count = func(c *cobra_cmd) {
+			n++
+			for _, k := range c.kids {
+				count(k)
+			}
+		}
+		count(c)
+		return n
+	}
+	for _, c := range all {
+		if c.parent != nil {
+			continue
+		}
+		if name, _ := cobra_use(d.str(field(c.lit, "Use"))); name == m.name {
+			root = c
+			break
+		}
+		if root == nil || size(c) > size(root) {
+			root = c
+		}
+	}
+	if root == nil {
+		//every one is added to another, round in a circle
+		return nil
+	}
+	for _, c := range all {
+		if c.parent == nil && c != root {
+			c.parent = root
+			root.kids = append(root.kids, c)
+		}
+	}
+
+	_, usage := cobra_use(d.str(field(root.lit, "Use")))
+	if fl.usage == "" {
+		fl.usage = usage
+	}
+	fl.flags = append(fl.flags, root.flags...)
+	short, long := d.str(field(root.lit, "Short")), d.str(field(root.lit, "Long"))
+	m.fallback_doc(short, long)
+	descrs = append(descrs, long)
+
+	var walk func(prefix string, c *cobra_cmd)
+	walk = func(prefix string, c *cobra_cmd) {
+		for _, k := range c.kids {
+			if d.truth(field(k.lit, "Hidden")) {
+				continue
+			}
+			name, usage := cobra_use(d.str(field(k.lit, "Use")))
+			if name == "" {
+				continue
+			}
+			name = strings.TrimSpace(prefix + " " + name)
+			doc := d.str(field(k.lit, "Short"))
+			if long := d.str(field(k.lit, "Long")); long != "" {
+				doc += "\n\n" + long
+			}
+			fl.cmds = append(fl.cmds, &subcmd{
+				name:  name,
+				doc:   doc,
+				usage: usage,
+				flags: k.flags,
+			})
+			descrs = append(descrs, doc)
+			walk(name, k)
+		}
+	}
+	walk("", root)
+	return descrs
+}
+
+//pflagrx matches the kinds of flag pflag can define: TypeP defines one with
+//a shorthand, TypeVar binds a variable and Var takes a pflag.Value.
+var pflagrx = RX("^((Bool|String|Int|Uint|Float|Duration|IP|Bytes|Count|Func)[A-Za-z0-9]*|Var)$")
+
+//pflag_opt is the option a call to method fn of a pflag.FlagSet defines, or
+//nil if it is not one that defines a flag.
+func pflag_opt(d *defs, fn string, args []ast.Expr) *opt {
+	base := fn
+	short := strings.HasSuffix(fn, "P") && !strings.HasSuffix(fn, "IP")
+	if short {
+		base = fn[:len(fn)-1]
+	}
+	bound := strings.HasSuffix(base, "Var")
+	typ := strings.TrimSuffix(base, "Var")
+	if !pflagrx.MatchString(base) {
+		return nil
+	}
+	fnc := typ == "Func" || typ == "BoolFunc"
+
+	//([p,] name, [shorthand,] [value,] usage [, fn])
+	n := 3
+	if bound && typ != "" {
+		n++
+	}
+	if short {
+		n++
+	}
+	if typ == "Count" {
+		n--
+	}
+	if len(args) != n {
+		return nil
+	}
+	i := 0
+	var val ast.Expr
+	if bound {
+		val = args[0]
+		i++
+	}
+	o := &opt{dashes: "--", pos: args[0].Pos()}
+	o.name = []byte(d.str(args[i]))
+	i++
+	if short {
+		if sh := d.str(args[i]); sh != "" {
+			o.aka = []string{"-" + sh}
+		}
+		i++
+	}
+	if typ != "" && typ != "Count" && !fnc {
+		if def := d.str(args[i]); def != "" {
+			o.def = []byte(def)
+		}
+		i++
+	}
+	o.help = []byte(d.str(args[i]))
+	o.nohelp = d.blank(args[i])
+	if len(o.name) == 0 {
+		return nil
+	}
+
+	switch typ {
+	case "Bool", "Count", "BoolFunc":
+		o.def = nil
+	case "", "Func":
+		o.varname = []byte("value")
+		if n := var_name(val); n != "" {
+			o.varname = []byte(n)
+		}
+	default:
+		o.varname = []byte(placeholder(typ))
+	}
+	return o
+}
@@ -4,6 +4,7 @@ import (
 	"unicode"
 	"strings"
 	"bytes"
+	"go/doc/comment"
 )
 
 type coll [][]byte
@@ -96,41 +97,113 @@ func locify(ls [][]byte) []*loc {
 	return ret
 }
 
-func partition(locs []*loc) (ret []interface{}) {
-	ln := len(locs)
-	if ln == 0 {
-		return
+//partition turns the blocks of a parsed comment into paragraphs.
+func partition(bs []comment.Block) (ret []interface{}) {
+	for _, b := range bs {
+		switch b := b.(type) {
+		case *comment.Heading:
+			ret = append(ret, heading(plain(b.Text)))
+		case *comment.Paragraph:
+			ret = append(ret, paragraph(plain(b.Text))...)
+		case *comment.Code:
+			ret = append(ret, code(b.Text))
+		case *comment.List:
+			l := &list{loose: b.BlankBetween()}
+			for _, it := range b.Items {
+				l.items = append(l.items, &item{it.Number, partition(it.Content)})
+			}
+			ret = append(ret, l)
+		}
 	}
-	for i := 0; i < ln; {
-		//skip blank lines
-		for ; i < ln && locs[i].indent == -1; i++ {
+	return
+}
+
+//code is a block of code, indented as one would be in a comment.
+func code(text string) []*loc {
+	locs := locify(lines([]byte(strings.TrimRight(text, "\n"))))
+	for _, l := range locs {
+		if l.indent != -1 {
+			//go/doc/comment removes the indent that marks it as code
+			l.indent++
+			l.line = bytes.TrimSpace(l.line)
 		}
-		//select mode
-		if locs[i].indent == 0 {
-			//paragraph mode
-			acc := newColl()
-			for ; i < ln && locs[i].indent == 0; i++ {
-				acc.push(locs[i].line)
-			}
-			ret = append(ret, sentences(acc.join()))
-		} else {
-			//"code" mode
-			start := i
-			for ; i < ln && locs[i].indent != 0; i++ {
-				locs[i].line = bytes.TrimSpace(locs[i].line)
+	}
+	return locs
+}
+
+//plain is text without its markup. A link is followed by its URL in angle
+//brackets unless the URL is the text, and words makes a hyperlink of that.
+func plain(ts []comment.Text) string {
+	var buf strings.Builder
+	for _, t := range ts {
+		switch t := t.(type) {
+		case comment.Plain:
+			buf.WriteString(string(t))
+		case comment.Italic:
+			buf.WriteString(string(t))
+		case *comment.Link:
+			buf.WriteString(plain(t.Text))
+			if !t.Auto {
+				buf.WriteString(" <" + t.URL + ">")
 			}
-			//TODO should cleave off any extraneous blank lines at the end
-			end := i
-			if end-start > 0 {
-				ret = append(ret, locs[start:end])
+		case *comment.DocLink:
+			buf.WriteString(plain(t.Text))
+		}
+	}
+	return buf.String()
+}
+
+//listrx matches the marker of a list item: a bullet, or a number and a dot or
+//paren, then space.
+var listrx = RX("^([-*+•]|[0-9]+[.)])[ \t]+")
+
+//paragraph is the text of a paragraph, and of the list it ends in if the
+//lines from some point on start with list markers without being indented as
+//go/doc/comment would want. Lines after an item that don't start another
+//one continue it.
+func paragraph(text string) []interface{} {
+	ls := strings.Split(text, "\n")
+	start, n := -1, 0
+	for i, line := range ls {
+		if listrx.MatchString(line) {
+			if start == -1 {
+				start = i
 			}
+			n++
 		}
 	}
-	return
+	if n < 2 {
+		return []interface{}{sentences([]byte(text))}
+	}
+
+	var ret []interface{}
+	if start > 0 {
+		ret = append(ret, sentences([]byte(strings.Join(ls[:start], "\n"))))
+	}
+	var marks, texts []string
+	for _, line := range ls[start:] {
+		if mk := listrx.FindStringSubmatch(line); mk != nil {
+			marks = append(marks, mk[1])
+			texts = append(texts, line[len(mk[0]):])
+		} else {
+			texts[len(texts)-1] += "\n" + line
+		}
+	}
+	l := &list{}
+	for i, mk := range marks {
+		it := &item{paras: []interface{}{sentences([]byte(texts[i]))}}
+		if unicode.IsDigit(rune(mk[0])) {
+			it.number = mk[:len(mk)-1]
+		}
+		l.items = append(l.items, it)
+	}
+	return append(ret, l)
 }
 
-func unstring(in []byte) []interface{} {
-	return partition(locify(lines(in)))
+//unstring reads a doc comment with p, which knows the names declared in and
+//imported by the package at hand, so [Name] links to them are known.
+func unstring(p *comment.Parser, in []byte) []interface{} {
+	return partition(p.Parse(string(in)).Content)
 }
 
 var srx = RX(NS + "[.!?][ \n\t]+")
@@ -142,7 +215,8 @@ func sentences(in []byte) [][]byte {
 		//inverse slices 'in' so this gets the last char and ending punctuation
 		out[i] = s[:len(s)+2]
 	}
-	if cap(out[last])-len(out[last]) >= 2 {
+	//only if the last match was at the very end of in, and not cut off
+	if end := cap(in) - cap(out[last]) + len(out[last]); end < len(in) {
 		out[last] = out[last][:len(out[last])+2]
 	}
 	return out
@@ -150,10 +224,29 @@ func sentences(in []byte) [][]byte {
 
 type section struct {
 	name  string
-	paras []interface{} // [][]byte, []byte, or []*loc
+	paras []interface{} // [][]byte, []byte, []*loc, heading, or *list
+}
+
+//heading is a "# Heading" line, or an old style one go/doc/comment knows.
+type heading string
+
+//list is a bulleted or numbered list.
+type list struct {
+	items []*item
+	loose bool //blank lines between items
 }
 
+type item struct {
+	number string //"" for a bullet
+	paras  []interface{}
+}
+
+//isSecHdr is true of headings and, as before there were headings, of
+//paragraphs that are one line in all caps.
 func isSecHdr(s interface{}) bool {
+	if _, ok := s.(heading); ok {
+		return true
+	}
 	p, ok := s.([][]byte)
 	if !ok || len(p) != 1 {
 		return false
@@ -170,30 +263,31 @@ func sections(src []interface{}) []*section {
 	if src == nil {
 		return nil
 	}
-	num, end := 1, 0
+	num, end := 1, -1
 	//check for other sections
 	for i, v := range src {
 		if isSecHdr(v) {
 			num++
 			//mark first sec header
-			if end == 0 {
+			if end == -1 {
 				end = i
 			}
 		}
 	}
-	if end == 0 {
+	if end == -1 {
 		return []*section{&section{"", src}}
 	}
 	secs := make([]*section, num)
 	secs[0] = &section{"", src[:end]}
 	start := end
 	for i := 1; i < num; i++ {
-		p, ok := src[start].([][]byte)
-		if !ok || len(p) > 1 {
-			start++
-			continue
+		var name string
+		switch p := src[start].(type) {
+		case heading:
+			name = strings.ToUpper(string(p))
+		case [][]byte:
+			name = string(p[0])
 		}
-		name := string(p[0])
 		start++
 		for end = start; end < len(src) && !isSecHdr(src[end]); end++ {
 		}
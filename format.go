@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
 	"strings"
 	"unicode"
 )
@@ -18,6 +20,10 @@ func escape(in []byte) []byte {
 		case rune('\\'):
 			buf.WriteString("\\e")
 			continue
+		case rune('"'): //would end a quoted macro argument
+			buf.WriteString("\\(dq")
+			last = r
+			continue
 		case rune('-'):
 			buf.WriteByte('\\')
 		case rune('.'):
@@ -37,77 +43,252 @@ func escape(in []byte) []byte {
 	return buf.Bytes()
 }
 
-type BR struct {
-	c, v []string
-	bold bool
+//F writes man(7) troff.
+type F struct {
+	*bytes.Buffer
+	lines bool //the last thing written was a line
 }
 
-func NewBR() *BR {
-	return &BR{[]string{}, []string{}, true}
+func Formatter() *F {
+	return &F{Buffer: &bytes.Buffer{}}
 }
 
-func (s *BR) witch() {
-	if len(s.c) == 0 {
-		return
+func (m *F) nl() {
+	if m.Len() > 0 && m.Bytes()[m.Len()-1] != '\n' {
+		m.WriteByte('\n')
 	}
-	s.v = append(s.v, "\""+strings.Join(s.c, "")+"\"")
-	s.c = []string{}
-	s.bold = !s.bold
 }
 
-func (s *BR) B(str string) {
-	if !s.bold {
-		s.witch()
+func (m *F) begin(name, sec, date, version, manual string) {
+	m.WriteString(".\\\"    Automatically generated by mango(1)")
+	m.WriteString(
+		fmt.Sprintf("\n.TH \"%s\" %s \"%s\" \"version %s\" \"%s\"",
+			name,
+			sec,
+			date,
+			version,
+			manual,
+		))
+}
+
+func (m *F) finish() {
+	m.nl()
+}
+
+func (m *F) name_line(name string, descr []byte) {
+	m.section("NAME")
+	m.WriteString(name)
+	if len(descr) > 0 {
+		m.WriteString(" \\- ")
+		m.Write(escape(descr)) //first sentence
 	}
-	s.c = append(s.c, str)
 }
 
-func (s *BR) R(str string) {
-	if s.bold {
-		s.witch()
+func (m *F) PP() {
+	m.nl()
+	m.WriteString(".PP\n")
+	m.lines = false
+}
+
+func (m *F) section(name string) {
+	m.nl()
+	m.WriteString(".SH \"")
+	m.WriteString(strings.TrimSpace(name))
+	m.WriteString("\"\n")
+	m.lines = false
+}
+
+func (m *F) subsection(name string) {
+	m.nl()
+	m.WriteString(".SS \"")
+	m.WriteString(strings.TrimSpace(name))
+	m.WriteString("\"\n")
+	m.lines = false
+}
+
+func (m *F) anchor(id string) {}
+
+func (m *F) indent() {
+	m.nl()
+	m.WriteString(".RS\n")
+	m.lines = false
+}
+
+func (m *F) outdent() {
+	m.nl()
+	m.WriteString(".RE\n")
+	m.lines = false
+}
+
+func (m *F) line(s ...span) {
+	m.nl()
+	if m.lines {
+		m.WriteString(".br\n")
 	}
-	s.c = append(s.c, str)
+	m.spans(s, false)
+	m.lines = true
 }
 
-type F struct {
-	*bytes.Buffer
-	BR *BR
+func (m *F) tag(s ...span) {
+	m.nl()
+	m.WriteString(".TP\n")
+	m.spans(s, true)
+	m.lines = false
 }
 
-func Formatter() *F {
-	return &F{&bytes.Buffer{}, NewBR()}
+//font is the font troff sets a kind of span in.
+func font(kind int) byte {
+	switch kind {
+	case sp_name, sp_flag, sp_go:
+		return 'B'
+	case sp_arg:
+		return 'I'
+	}
+	return 'R'
+}
+
+//a run is text in one font
+type run struct {
+	font byte
+	text string
 }
 
-func (m *F) br() {
-	m.BR.witch()
-	if len(m.BR.v) == 0 {
+//spans writes s as lines of macros that alternate between two fonts, as in
+//.BR "func New(" "n" " string) *T", breaking it where there are spaces when
+//it takes more than two, unless it has to be one line.
+func (m *F) spans(s []span, one bool) {
+	var words [][]*run
+	word := func(f byte, t string) {
+		for i, w := range strings.Split(t, " ") {
+			if i != 0 || len(words) == 0 {
+				words = append(words, nil)
+			}
+			if w != "" {
+				l := len(words) - 1
+				words[l] = append(words[l], &run{f, w})
+			}
+		}
+	}
+	for _, sp := range s {
+		if sp.kind == sp_ref {
+			name, sec := split_ref(sp.text)
+			word('B', name)
+			word('R', "("+sec+")")
+			continue
+		}
+		word(font(sp.kind), sp.text)
+	}
+
+	//put as many words on a line as fit in two fonts
+	var lines [][]*run
+	var line []*run
+	fonts := map[byte]bool{}
+	for _, w := range words {
+		if len(w) == 0 {
+			continue
+		}
+		wf := map[byte]bool{}
+		for _, r := range w {
+			wf[r.font] = true
+		}
+		for f := range fonts {
+			wf[f] = true
+		}
+		if len(wf) > 2 {
+			lines = append(lines, line)
+			line, wf = nil, map[byte]bool{}
+			for _, r := range w {
+				wf[r.font] = true
+			}
+		} else if len(line) > 0 {
+			line = append(line, &run{line[len(line)-1].font, " "})
+		}
+		line = append(line, w...)
+		fonts = wf
+	}
+	lines = append(lines, line)
+
+	if !one || len(lines) == 1 {
+		for _, l := range lines {
+			m.runs(l)
+		}
 		return
 	}
-	m.WriteString(".BR " + strings.Join(m.BR.v, " ") + "\n")
-	m.BR.v = []string{}
-	m.BR.bold = true
+	//a tag has to be one line, so change fonts in it
+	m.nl()
+	m.WriteString("\\&")
+	for i, l := range lines {
+		if i != 0 {
+			m.WriteByte(' ')
+		}
+		for _, r := range merge(l) {
+			m.WriteString("\\f")
+			m.WriteByte(r.font)
+			m.Write(escape([]byte(r.text)))
+		}
+	}
+	m.WriteString("\\fR\n")
 }
 
-func (m *F) nl() {
-	if m.Bytes()[m.Len()-1] != '\n' {
-		m.WriteByte('\n')
+//merge joins the runs in the same font next to each other.
+func merge(rs []*run) (out []*run) {
+	for _, r := range rs {
+		if l := len(out); l > 0 && out[l-1].font == r.font {
+			out[l-1] = &run{r.font, out[l-1].text + r.text}
+		} else {
+			out = append(out, r)
+		}
 	}
+	return out
 }
 
-func (m *F) PP() {
+//runs writes a line of runs in no more than two fonts.
+func (m *F) runs(rs []*run) {
+	merged := merge(rs)
+	if len(merged) == 0 {
+		return
+	}
+	m.nl()
+	if len(merged) == 1 && merged[0].font == 'R' {
+		m.WriteString("\\&")
+		m.Write(escape([]byte(merged[0].text)))
+		m.nl()
+		return
+	}
+	m.WriteByte('.')
+	m.WriteByte(merged[0].font)
+	if len(merged) > 1 {
+		m.WriteByte(merged[1].font)
+	}
+	for _, r := range merged {
+		m.WriteByte(' ')
+		if strings.ContainsAny(r.text, " \t") {
+			m.WriteString("\"" + string(escape([]byte(r.text))) + "\"")
+		} else {
+			m.Write(escape([]byte(r.text)))
+		}
+	}
 	m.nl()
-	m.WriteString(".PP\n")
 }
 
-func (m *F) section(name string) {
-	m.nl()
-	m.WriteString(".SH \"")
-	m.WriteString(strings.TrimSpace(name))
-	m.WriteString("\"\n")
+func (m *F) see_also(refs []string) {
+	m.section("SEE ALSO")
+	for i, s := range refs {
+		if i != 0 {
+			m.WriteString(",\n")
+		}
+		name, sec := split_ref(s)
+		m.WriteString(".BR ")
+		m.Write(escape([]byte(name)))
+		m.WriteString(" (" + sec + ")")
+	}
 }
 
 var wrx = RX("[ \n\t]")
 
+//switchrx matches words that look like a switch, and not a bullet or a dash
+var switchrx = RX("^--?[A-Za-z]")
+
 func (m *F) words(sentence []byte) {
 	for _, word := range inverseMatch(wrx, bytes.TrimSpace(sentence)) {
 		word = bytes.TrimSpace(word)
@@ -115,10 +296,22 @@ func (m *F) words(sentence []byte) {
 			continue
 		}
 		switch {
-		case bytes.HasPrefix(word, []byte("-")):
+		case switchrx.Match(word):
+			m.nl()
+			m.WriteString(".B ")
+			m.Write(escape(word))
+			m.nl()
+		case urlrx.Match(word):
+			//a link; plain leaves the URL of one with text in <>
+			sub := urlrx.FindSubmatch(word)
 			m.nl()
-			m.WriteString(".B \\")
-			m.Write(word)
+			m.WriteString(".UR ")
+			m.Write(sub[1])
+			m.WriteString("\n.UE")
+			if len(sub[2]) > 0 {
+				m.WriteByte(' ')
+				m.Write(escape(sub[2]))
+			}
 			m.nl()
 		case refrx.Match(word): //defined above find_refs()
 			m.nl()
@@ -140,6 +333,7 @@ func (m *F) text(p []byte) {
 		m.nl()
 		m.words(s)
 	}
+	m.lines = false
 }
 
 func (m *F) paras(ps []interface{}) {
@@ -147,46 +341,88 @@ func (m *F) paras(ps []interface{}) {
 		if i != 0 {
 			m.PP()
 		}
-		switch p := P.(type) {
-		case []byte: // raw section
+		m.para(P)
+	}
+	m.lines = false
+}
+
+func (m *F) para(P interface{}) {
+	switch p := P.(type) {
+	case []byte: // raw section
+		m.nl()
+		m.Write(p)
+		m.nl()
+	case [][]byte:
+		for _, s := range p {
+			m.nl()
+			m.words(s)
+		}
+	case heading: //only within a section, so a subsection
+		m.nl()
+		m.WriteString(".SS \"")
+		m.WriteString(strings.TrimSpace(string(p)))
+		m.WriteString("\"\n")
+	case *list:
+		//hang the items as far in as the widest number needs
+		w := 2
+		for _, it := range p.items {
+			if len(it.number)+2 > w {
+				w = len(it.number) + 2
+			}
+		}
+		ind := " " + strconv.Itoa(w) + "\n"
+		if !p.loose {
 			m.nl()
-			m.Write(p)
+			m.WriteString(".PD 0\n")
+		}
+		for _, it := range p.items {
 			m.nl()
-		case [][]byte:
-			for _, s := range p {
-				m.nl()
-				m.words(s)
+			if it.number != "" {
+				m.WriteString(".IP " + it.number + "." + ind)
+			} else {
+				m.WriteString(".IP \\(bu" + ind)
 			}
-		case []*loc:
-			last, depth := 0, 0
-			for j, loc := range p {
-				m.nl()
-				line, in := loc.line, loc.indent
-				if in == -1 {
-					m.WriteString(".sp\n")
-					continue
-				} else if last < in {
-					depth++
-					m.WriteString(".RS\n")
-				} else if last > in {
-					depth--
-					if depth < 0 {
-						fatal("Impossible indentation.")
-					}
-					m.WriteString(".RE\n")
+			for j, ip := range it.paras {
+				if j != 0 {
+					m.nl()
+					m.WriteString(".IP\n")
 				}
-				m.Write(escape(line))
-				m.nl()
-				if j != len(p)-1 {
-					m.WriteString(".sp 0\n")
+				m.para(ip)
+			}
+		}
+		if !p.loose {
+			m.nl()
+			m.WriteString(".PD\n")
+		}
+	case []*loc:
+		last, depth := 0, 0
+		for j, loc := range p {
+			m.nl()
+			line, in := loc.line, loc.indent
+			if in == -1 {
+				m.WriteString(".sp\n")
+				continue
+			} else if last < in {
+				depth++
+				m.WriteString(".RS\n")
+			} else if last > in {
+				depth--
+				if depth < 0 {
+					fatal("Impossible indentation.")
 				}
-				last = in
+				m.WriteString(".RE\n")
 			}
-			//make sure we unindent as much as we've indented
-			for ; depth > 0; depth-- {
-				m.nl()
-				m.WriteString(".RE")
+			m.Write(escape(line))
+			m.nl()
+			if j != len(p)-1 {
+				m.WriteString(".sp 0\n")
 			}
+			last = in
+		}
+		//make sure we unindent as much as we've indented
+		for ; depth > 0; depth-- {
+			m.nl()
+			m.WriteString(".RE")
 		}
 	}
 }
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"bytes"
+	"html"
+	"strings"
+)
+
+//H writes a standalone HTML page, laid out like a man page.
+type H struct {
+	*bytes.Buffer
+	open []string //what closes the elements left open, innermost last
+	next string   //id for the next thing written
+}
+
+func HTML() *H {
+	return &H{Buffer: &bytes.Buffer{}}
+}
+
+const html_style = `body { max-width: 48em; margin: 2em auto; padding: 0 1em; font-family: sans-serif; line-height: 1.4; }
+header, footer { display: flex; justify-content: space-between; color: #555; }
+h2 { font-size: 1.1em; margin-top: 1.5em; }
+h3 { font-size: 1em; }
+section, .indent, dd { margin-left: 2em; }
+dt { margin-top: .5em; }
+dd { margin-bottom: .5em; }
+.line { font-family: monospace; }
+pre { margin-left: 2em; }
+code, pre, var { font-family: monospace; }
+a { color: inherit; }`
+
+func (m *H) begin(name, sec, date, version, manual string) {
+	title := html.EscapeString(name + "(" + sec + ")")
+	m.WriteString("<!DOCTYPE html>\n<!-- Automatically generated by mango(1) -->\n")
+	m.WriteString("<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	m.WriteString("<title>" + title + "</title>\n")
+	m.WriteString("<style>\n" + html_style + "\n</style>\n</head>\n<body>\n")
+	m.WriteString("<header><span>" + title + "</span><span>" +
+		html.EscapeString(manual) + "</span><span>" + title + "</span></header>\n")
+	//the footer needs these at the end
+	m.open = []string{"<footer><span>version " + html.EscapeString(version) +
+		"</span><span>" + html.EscapeString(date) + "</span></footer>\n</body>\n</html>\n"}
+}
+
+func (m *H) finish() {
+	m.close(0)
+}
+
+//close closes all but the first n open elements.
+func (m *H) close(n int) {
+	for len(m.open) > n {
+		m.pop()
+	}
+}
+
+func (m *H) push(open, close string) {
+	m.WriteString(open)
+	m.open = append(m.open, close)
+}
+
+func (m *H) pop() string {
+	last := m.open[len(m.open)-1]
+	m.open = m.open[:len(m.open)-1]
+	m.WriteString(last)
+	return last
+}
+
+func (m *H) top() string {
+	if len(m.open) == 0 {
+		return ""
+	}
+	return m.open[len(m.open)-1]
+}
+
+//id is the id attribute for the anchor, if one is waiting.
+func (m *H) id() string {
+	if m.next == "" {
+		return ""
+	}
+	id := ` id="` + html.EscapeString(m.next) + `"`
+	m.next = ""
+	return id
+}
+
+func (m *H) name_line(name string, descr []byte) {
+	m.section("NAME")
+	m.WriteString("<p>" + html.EscapeString(name))
+	if len(descr) > 0 {
+		m.WriteString(" &mdash; " + html.EscapeString(string(descr)))
+	}
+	m.WriteString("</p>\n")
+}
+
+func (m *H) section(name string) {
+	m.close(1)
+	name = strings.TrimSpace(name)
+	id := strings.Replace(name, " ", "-", -1)
+	m.WriteString(`<h2 id="` + html.EscapeString(id) + `">` + html.EscapeString(name) + "</h2>\n")
+	m.push("<section>\n", "</section>\n")
+}
+
+func (m *H) subsection(name string) {
+	m.close(2)
+	m.WriteString("<h3>" + html.EscapeString(strings.TrimSpace(name)) + "</h3>\n")
+}
+
+func (m *H) anchor(id string) {
+	if m.next != "" {
+		//only one id fits on the next element
+		m.WriteString(`<span id="` + html.EscapeString(m.next) + `"></span>`)
+	}
+	m.next = id
+}
+
+func (m *H) PP() {
+	//like .PP, end a tagged paragraph
+	if m.top() == "</dd>\n" {
+		m.pop()
+		m.pop()
+	}
+}
+
+func (m *H) indent() {
+	m.push("<div class=\"indent\">\n", "</div>\n")
+}
+
+func (m *H) outdent() {
+	for len(m.open) > 0 && m.pop() != "</div>\n" {
+	}
+}
+
+func (m *H) line(s ...span) {
+	m.WriteString("<div class=\"line\"" + m.id() + ">")
+	m.spans(s)
+	m.WriteString("</div>\n")
+}
+
+func (m *H) tag(s ...span) {
+	if m.top() == "</dd>\n" {
+		m.pop()
+	} else {
+		m.push("<dl>\n", "</dl>\n")
+	}
+	m.WriteString("<dt" + m.id() + ">")
+	m.spans(s)
+	m.WriteString("</dt>\n")
+	m.push("<dd>\n", "</dd>\n")
+}
+
+func (m *H) spans(s []span) {
+	for _, sp := range s {
+		t := html.EscapeString(sp.text)
+		if sp.link != "" {
+			t = `<a href="#` + html.EscapeString(sp.link) + `">` + t + "</a>"
+		}
+		switch sp.kind {
+		case sp_name, sp_flag:
+			t = "<b>" + t + "</b>"
+		case sp_arg, sp_param:
+			t = "<var>" + t + "</var>"
+		case sp_go:
+			t = "<code>" + t + "</code>"
+		case sp_ref:
+			t = html_ref(sp.text)
+		}
+		m.WriteString(t)
+	}
+}
+
+//html_ref links a reference like ls(1) to the page -o would write for it.
+func html_ref(ref string) string {
+	name, sec := split_ref(ref)
+	return `<a href="` + html.EscapeString(name+"."+sec+".html") + `"><b>` +
+		html.EscapeString(name) + "</b>(" + html.EscapeString(sec) + ")</a>"
+}
+
+func (m *H) see_also(refs []string) {
+	m.section("SEE ALSO")
+	m.WriteString("<p>")
+	for i, r := range refs {
+		if i != 0 {
+			m.WriteString(",\n")
+		}
+		m.WriteString(html_ref(r))
+	}
+	m.WriteString("</p>\n")
+}
+
+func (m *H) text(p []byte) {
+	m.WriteString("<p" + m.id() + ">")
+	m.words(p)
+	m.WriteString("</p>\n")
+}
+
+//linkable is whether u is a URL to make a link of, which those that run
+//something when clicked, like javascript://, are not.
+func linkable(u string) bool {
+	switch u[:strings.Index(u, ":")] {
+	case "http", "https", "ftp", "mailto":
+		return true
+	}
+	return false
+}
+
+func (m *H) words(p []byte) {
+	for i, word := range strings.Fields(string(p)) {
+		if i != 0 {
+			m.WriteByte(' ')
+		}
+		switch {
+		case switchrx.MatchString(word):
+			m.WriteString("<b>" + html.EscapeString(word) + "</b>")
+		case urlrx.MatchString(word):
+			sub := urlrx.FindStringSubmatch(word)
+			if !linkable(sub[1]) {
+				m.WriteString(html.EscapeString(word))
+				break
+			}
+			u := html.EscapeString(sub[1])
+			m.WriteString(`<a href="` + u + `">` + u + "</a>" + html.EscapeString(sub[2]))
+		case refrx.MatchString(word):
+			m.WriteString(html_ref(word))
+		default:
+			m.WriteString(html.EscapeString(word))
+		}
+	}
+}
+
+func (m *H) paras(ps []interface{}) {
+	for _, P := range ps {
+		m.para(P)
+	}
+}
+
+func (m *H) para(P interface{}) {
+	switch p := P.(type) {
+	case []byte: //raw troff from -include, as is
+		m.WriteString("<pre>" + html.EscapeString(string(p)) + "</pre>\n")
+	case [][]byte:
+		m.text(bytes.Join(p, []byte(" ")))
+	case heading:
+		m.WriteString("<h3>" + html.EscapeString(strings.TrimSpace(string(p))) + "</h3>\n")
+	case *list:
+		tag := "ul"
+		if len(p.items) > 0 && p.items[0].number != "" {
+			tag = "ol"
+		}
+		m.WriteString("<" + tag + ">\n")
+		for _, it := range p.items {
+			m.WriteString("<li>")
+			m.paras(it.paras)
+			m.WriteString("</li>\n")
+		}
+		m.WriteString("</" + tag + ">\n")
+	case []*loc:
+		m.WriteString("<pre>")
+		for _, l := range p {
+			if l.indent > 0 {
+				m.WriteString(strings.Repeat("    ", l.indent-1))
+			}
+			m.WriteString(html.EscapeString(strings.TrimRight(string(l.line), "\n")))
+			m.WriteByte('\n')
+		}
+		m.WriteString("</pre>\n")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+//J writes the tree of a page as JSON, for programs that want what mango
+//extracted without reading troff or Go.
+//Fields are only ever added to the schema; a change to what one means
+//comes with a new json_schema.
+type J struct {
+	*bytes.Buffer
+}
+
+func JSON() *J {
+	return &J{Buffer: &bytes.Buffer{}}
+}
+
+const json_schema = 1
+
+type json_page struct {
+	Schema       int             `json:"schema"`
+	Name         string          `json:"name"`
+	Section      string          `json:"section"`
+	Version      string          `json:"version"`
+	Date         string          `json:"date"`
+	Manual       string          `json:"manual"`
+	Description  string          `json:"description"`
+	Sections     []*json_section `json:"sections"`
+	Flags        []*json_flag    `json:"flags"`
+	Declarations []*json_decl    `json:"declarations"`
+	Bugs         []string        `json:"bugs"`
+	References   []string        `json:"references"`
+}
+
+type json_section struct {
+	Name   string        `json:"name"`
+	Blocks []*json_block `json:"blocks"`
+}
+
+//json_block is one of the blocks of a body, or of a paragraph from a
+//comment, told apart by Type:
+//break, paragraph, raw, code, heading, list, anchor, line, tags, indent
+//and subsection.
+type json_block struct {
+	Type    string        `json:"type"`
+	Name    string        `json:"name,omitempty"`
+	Text    string        `json:"text,omitempty"`
+	ID      string        `json:"id,omitempty"`
+	IDs     []string      `json:"ids,omitempty"`
+	Spans   []*json_span  `json:"spans,omitempty"`
+	Lines   []string      `json:"lines,omitempty"`
+	Ordered bool          `json:"ordered,omitempty"`
+	Loose   bool          `json:"loose,omitempty"`
+	Items   []*json_item  `json:"items,omitempty"`
+	Blocks  []*json_block `json:"blocks,omitempty"`
+}
+
+//json_item is an item of a list or of tags.
+type json_item struct {
+	Number string        `json:"number,omitempty"`
+	IDs    []string      `json:"ids,omitempty"`
+	Term   string        `json:"term,omitempty"`
+	Spans  []*json_span  `json:"spans,omitempty"`
+	Flag   *json_flag    `json:"flag,omitempty"`
+	Blocks []*json_block `json:"blocks"`
+}
+
+type json_span struct {
+	Kind string `json:"kind"`
+	Text string `json:"text"`
+	Link string `json:"link,omitempty"`
+}
+
+type json_flag struct {
+	Names       []string `json:"names"`
+	Placeholder string   `json:"placeholder,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Help        string   `json:"help"`
+	Command     string   `json:"command,omitempty"` //the subcommand it belongs to
+}
+
+//json_decl is a Go declaration, as it is shown, and what it declares.
+type json_decl struct {
+	IDs  []string `json:"ids"`
+	Text string   `json:"text"`
+}
+
+var span_kinds = []string{
+	sp_text:  "text",
+	sp_name:  "name",
+	sp_flag:  "flag",
+	sp_arg:   "arg",
+	sp_go:    "go",
+	sp_param: "param",
+	sp_ref:   "ref",
+}
+
+//json_walk gathers what is spread around the page as it converts it.
+type json_walk struct {
+	out   *json_page
+	decl  *json_decl //the declaration being read
+	depth int        //how far its lines are indented, as in a struct
+	cmd   string     //the subcommand being read
+}
+
+func (j *J) write(p *man_page) {
+	w := &json_walk{out: &json_page{
+		Schema:       json_schema,
+		Name:         p.name,
+		Section:      p.sec,
+		Version:      p.version,
+		Date:         p.date,
+		Manual:       p.manual,
+		Description:  string(p.descr),
+		Sections:     []*json_section{},
+		Flags:        []*json_flag{},
+		Declarations: []*json_decl{},
+		Bugs:         []string{},
+		References:   []string{},
+	}}
+	for _, s := range p.sections {
+		if s.refs != nil {
+			w.out.References = append(w.out.References, s.refs...)
+			continue
+		}
+		w.decl = nil //a declaration does not run on into the next section
+		js := &json_section{Name: s.name, Blocks: w.blocks(s.blocks)}
+		w.out.Sections = append(w.out.Sections, js)
+		if s.name == "BUGS" {
+			for _, b := range js.Blocks {
+				if b.Type == "paragraph" {
+					w.out.Bugs = append(w.out.Bugs, b.Text)
+				}
+			}
+		}
+	}
+	//only what declares something, not an import
+	var ds []*json_decl
+	for _, d := range w.out.Declarations {
+		if len(d.IDs) > 0 {
+			ds = append(ds, d)
+		}
+	}
+	w.out.Declarations = append([]*json_decl{}, ds...)
+
+	b, err := json.MarshalIndent(w.out, "", "\t")
+	if err != nil {
+		fatal(err)
+	}
+	j.Write(b)
+	j.WriteByte('\n')
+}
+
+func json_spans(s []span) (out []*json_span) {
+	for _, sp := range s {
+		out = append(out, &json_span{span_kinds[sp.kind], sp.text, sp.link})
+	}
+	return out
+}
+
+//is_go is whether a line is Go source, rather than a synopsis or a list.
+func is_go(s []span) bool {
+	for _, sp := range s {
+		if sp.kind == sp_go || sp.kind == sp_param {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *json_walk) blocks(bs []interface{}) (out []*json_block) {
+	out = []*json_block{}
+	for _, x := range bs {
+		switch x := x.(type) {
+		case brk:
+			w.decl = nil
+			out = append(out, &json_block{Type: "break"})
+		case prose:
+			w.decl = nil
+			out = append(out, &json_block{Type: "paragraph", Text: string(bytes.TrimSpace(x))})
+		case paras:
+			w.decl = nil
+			for _, p := range x {
+				out = append(out, json_para(p))
+			}
+		case anchor:
+			out = append(out, &json_block{Type: "anchor", ID: string(x)})
+		case *declaration:
+			out = append(out, &json_block{Type: "line", IDs: x.ids, Text: plain_spans(x.line),
+				Spans: json_spans(x.line)})
+			w.declaration(x)
+		case *tag_list:
+			out = append(out, w.tags(x))
+		case *indented:
+			w.depth++
+			out = append(out, &json_block{Type: "indent", Blocks: w.blocks(x.blocks)})
+			w.depth--
+		case *subsection:
+			w.decl = nil
+			out = append(out, &json_block{Type: "subsection", Name: x.name, Blocks: w.blocks(x.blocks)})
+		}
+	}
+	return out
+}
+
+//declaration adds a line of Go to the declaration being read, or starts one.
+func (w *json_walk) declaration(d *declaration) {
+	if !is_go(d.line) {
+		w.decl = nil
+		return
+	}
+	line := strings.Repeat("\t", w.depth) + plain_spans(d.line)
+	if w.decl == nil {
+		w.decl = &json_decl{IDs: []string{}, Text: line}
+		w.out.Declarations = append(w.out.Declarations, w.decl)
+	} else {
+		w.decl.Text += "\n" + line
+	}
+	w.decl.IDs = append(w.decl.IDs, d.ids...)
+}
+
+func (w *json_walk) tags(tl *tag_list) *json_block {
+	b := &json_block{Type: "tags"}
+	for _, it := range tl.items {
+		ji := &json_item{IDs: it.ids, Term: plain_spans(it.term), Spans: json_spans(it.term)}
+		if o := it.opt; o != nil {
+			ji.Flag = &json_flag{
+				Names:       o.forms(),
+				Placeholder: string(o.varname),
+				Default:     string(o.def),
+				Help:        string(bytes.TrimSpace(o.help)),
+				Command:     w.cmd,
+			}
+			w.out.Flags = append(w.out.Flags, ji.Flag)
+			ji.Blocks = w.blocks(it.blocks)
+		} else {
+			//the options of a subcommand are within its item
+			cmd := w.cmd
+			w.cmd = ji.Term
+			ji.Blocks = w.blocks(it.blocks)
+			w.cmd = cmd
+		}
+		b.Items = append(b.Items, ji)
+	}
+	w.decl = nil
+	return b
+}
+
+//json_para converts a paragraph from a comment.
+func json_para(P interface{}) *json_block {
+	switch p := P.(type) {
+	case []byte:
+		return &json_block{Type: "raw", Text: string(p)}
+	case [][]byte:
+		var ss []string
+		for _, s := range p {
+			ss = append(ss, string(bytes.TrimSpace(s)))
+		}
+		return &json_block{Type: "paragraph", Text: strings.Join(ss, " ")}
+	case heading:
+		return &json_block{Type: "heading", Text: strings.TrimSpace(string(p))}
+	case *list:
+		b := &json_block{Type: "list", Loose: p.loose}
+		for _, it := range p.items {
+			b.Ordered = b.Ordered || it.number != ""
+			ji := &json_item{Number: it.number, Blocks: []*json_block{}}
+			for _, ip := range it.paras {
+				ji.Blocks = append(ji.Blocks, json_para(ip))
+			}
+			b.Items = append(b.Items, ji)
+		}
+		return b
+	case []*loc:
+		b := &json_block{Type: "code"}
+		for _, l := range p {
+			line := strings.TrimRight(string(l.line), "\n")
+			if l.indent > 0 {
+				line = strings.Repeat("\t", l.indent-1) + line
+			}
+			b.Lines = append(b.Lines, line)
+		}
+		return b
+	}
+	return &json_block{Type: "unknown"}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/doc"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+//std_sections are the sections man-pages(7) names, and those mango puts in
+//a place of their own.
+var std_sections = []string{
+	"NAME", "SYNOPSIS", "CONFIGURATION", "DESCRIPTION", "OPTIONS",
+	"EXIT STATUS", "RETURN VALUE", "ERRORS", "ENVIRONMENT", "FILES",
+	"ATTRIBUTES", "VERSIONS", "STANDARDS", "HISTORY", "NOTES", "CAVEATS",
+	"BUGS", "EXAMPLES", "AUTHORS", "REPORTING BUGS", "COPYRIGHT", "SEE ALSO",
+	"DIAGNOSTICS", "COMMANDS",
+}
+
+//linter gathers what is wrong with the comments of a package.
+type linter struct {
+	fs    *token.FileSet
+	m     *M
+	pages map[string]bool //the pages being made, as name(sec)
+	man   []string        //the directories man looks for pages in
+	found []problem
+}
+
+type problem struct {
+	at  token.Position
+	msg string
+}
+
+//lint reports where the comments of pkgs break the rules mango reads them
+//by, or leave something out of their pages, and exits 1 if they do.
+func lint(fs *token.FileSet, pkgs []*packages.Package, o *options) {
+	single_flags(pkgs, o)
+	var ms []*M
+	pages := map[string]bool{}
+	for _, p := range with_files(pkgs) {
+		m := page(fs, p, o)
+		ms = append(ms, m)
+		for _, pg := range append([]*M{m}, m.subs...) {
+			pages[pg.name+"("+pg.sec+")"] = true
+		}
+	}
+	mp := man_path()
+	n := 0
+	for _, m := range ms {
+		l := &linter{fs: fs, m: m, pages: pages, man: mp}
+		l.package_doc()
+		l.flags(m.pg.sections)
+		for _, sub := range m.subs {
+			l.flags(sub.pg.sections)
+		}
+		l.sections()
+		if m.sec == "3" {
+			l.exported()
+		}
+		l.see_also()
+		sort.SliceStable(l.found, func(i, j int) bool {
+			a, b := l.found[i].at, l.found[j].at
+			return a.Filename < b.Filename || a.Filename == b.Filename && a.Line < b.Line
+		})
+		for _, p := range l.found {
+			fmt.Printf("%s:%d: %s\n", p.at.Filename, p.at.Line, p.msg)
+		}
+		n += len(l.found)
+	}
+	if n > 0 {
+		stderr(fmt.Sprintf("%d problems", n))
+		os.Exit(1)
+	}
+}
+
+//report notes a problem at pos, with the file relative to the current
+//directory if it is in it.
+func (l *linter) report(pos token.Pos, format string, args ...interface{}) {
+	p := l.fs.Position(pos)
+	if wd, err := os.Getwd(); err == nil {
+		if rel, err := filepath.Rel(wd, p.Filename); err == nil && !strings.HasPrefix(rel, "..") {
+			p.Filename = rel
+		}
+	}
+	l.found = append(l.found, problem{p, fmt.Sprintf(format, args...)})
+}
+
+//doc_file is the file with the package comment, or else the first file.
+func (l *linter) doc_file() *ast.File {
+	fs := files(l.m.pkg)
+	for _, f := range fs {
+		if f.Doc != nil {
+			return f
+		}
+	}
+	return fs[0]
+}
+
+func (l *linter) package_doc() {
+	f := l.doc_file()
+	if f.Doc == nil {
+		l.report(f.Package, "package %s has no package comment to make the page from", l.m.pkg.Name)
+		return
+	}
+	descr := strings.TrimSpace(string(l.m.descr))
+	switch {
+	case descr == "":
+		l.report(f.Doc.Pos(), "the package comment does not start with a sentence for NAME")
+	case len(l.m.name)+3+len(descr) > 80:
+		l.report(f.Doc.Pos(), "the first sentence of the package comment is too long for NAME: %d characters", len(descr))
+	}
+}
+
+//flags reports the flags whose help is left out or blank in the sections ss,
+//but not those whose help is made in a way mango cannot follow.
+func (l *linter) flags(ss []*page_section) {
+	var walk func(bs []interface{})
+	walk = func(bs []interface{}) {
+		for _, x := range bs {
+			switch x := x.(type) {
+			case *tag_list:
+				for _, it := range x.items {
+					if o := it.opt; o != nil && o.nohelp {
+						l.report(o.pos, "flag %s has no help", o.forms()[0])
+					}
+					walk(it.blocks)
+				}
+			case *indented:
+				walk(x.blocks)
+			case *subsection:
+				walk(x.blocks)
+			}
+		}
+	}
+	for _, s := range ss {
+		walk(s.blocks)
+	}
+}
+
+//sections reports the sections of the package comment that look like a
+//standard section, but are not.
+func (l *linter) sections() {
+	for _, s := range l.m.sections {
+		if s.name == "" {
+			continue
+		}
+		if std := misspelled(s.name); std != "" {
+			l.report(l.doc_line(s.name), "section %s should be %s", s.name, std)
+		}
+	}
+}
+
+//misspelled is the standard section name name is close to, if it is not one.
+func misspelled(name string) string {
+	for _, std := range std_sections {
+		if name == std {
+			return ""
+		}
+	}
+	for _, std := range std_sections {
+		d := distance(name, std)
+		if d == 1 || d == 2 && len(std) > 5 {
+			return std
+		}
+	}
+	return ""
+}
+
+//distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	row := make([]int, len(b)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur := min3(row[j]+1, row[j-1]+1, prev+cost)
+			prev, row[j] = row[j], cur
+		}
+	}
+	return row[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+//doc_line is where the heading hd is in the package comment, or the comment
+//itself if it cannot be found.
+func (l *linter) doc_line(hd string) token.Pos {
+	f := l.doc_file()
+	if f.Doc == nil {
+		return f.Package
+	}
+	for _, c := range f.Doc.List {
+		for i, line := range strings.Split(c.Text, "\n") {
+			line = strings.TrimLeft(line, "/* \t")
+			line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+			if strings.ToUpper(line) == hd {
+				return l.fs.File(c.Pos()).LineStart(l.fs.Position(c.Pos()).Line + i)
+			}
+		}
+	}
+	return f.Doc.Pos()
+}
+
+//exported reports the exported identifiers of a package without a comment.
+func (l *linter) exported() {
+	d := l.m.docs
+	l.values(d.Consts)
+	l.values(d.Vars)
+	l.funcs(d.Funcs, "")
+	for _, t := range d.Types {
+		if !token.IsExported(t.Name) {
+			continue
+		}
+		if t.Doc == "" {
+			l.report(t.Decl.Pos(), "exported type %s has no comment", t.Name)
+		}
+		l.values(t.Consts)
+		l.values(t.Vars)
+		l.funcs(t.Funcs, "")
+		l.funcs(t.Methods, t.Name+".")
+	}
+}
+
+func (l *linter) values(vs []*doc.Value) {
+	for _, v := range vs {
+		if v.Doc != "" {
+			continue
+		}
+		for _, sp := range v.Decl.Specs {
+			vs := sp.(*ast.ValueSpec)
+			if vs.Doc != nil || vs.Comment != nil {
+				continue
+			}
+			for _, n := range vs.Names {
+				if token.IsExported(n.Name) {
+					l.report(n.Pos(), "exported %s %s has no comment", v.Decl.Tok, n.Name)
+				}
+			}
+		}
+	}
+}
+
+func (l *linter) funcs(fs []*doc.Func, recv string) {
+	for _, f := range fs {
+		if f.Doc == "" && token.IsExported(f.Name) {
+			l.report(f.Decl.Pos(), "exported func %s%s has no comment", recv, f.Name)
+		}
+	}
+}
+
+//see_also reports the references in SEE ALSO to pages that are neither
+//installed nor being made.
+func (l *linter) see_also() {
+	for _, r := range l.m.refs {
+		if l.pages[r] || installed(r, l.man) {
+			continue
+		}
+		l.report(l.ref_pos(r), "%s is in SEE ALSO but is not installed", r)
+	}
+}
+
+//ref_pos is where the reference r is first made in a comment of the package.
+func (l *linter) ref_pos(r string) token.Pos {
+	for _, f := range files(l.m.pkg) {
+		for _, cg := range f.Comments {
+			for _, c := range cg.List {
+				if i := strings.Index(c.Text, r); i >= 0 {
+					return c.Pos() + token.Pos(i)
+				}
+			}
+		}
+	}
+	return l.doc_file().Package
+}
+
+//man_path are the directories man looks for pages in.
+func man_path() []string {
+	mp := os.Getenv("MANPATH")
+	if mp == "" {
+		if out, err := exec.Command("manpath", "-q").Output(); err == nil {
+			mp = strings.TrimSpace(string(out))
+		}
+	}
+	var out []string
+	for _, d := range strings.Split(mp, ":") {
+		if d != "" {
+			out = append(out, d)
+		}
+	}
+	//an empty entry, or none at all, means the usual places
+	if len(out) == 0 || strings.HasPrefix(mp, ":") || strings.HasSuffix(mp, ":") || strings.Contains(mp, "::") {
+		out = append(out, "/usr/share/man", "/usr/local/share/man")
+	}
+	return out
+}
+
+//installed is whether the page r, as name(sec), is in one of the directories
+//of the man path mp.
+func installed(r string, mp []string) bool {
+	name, sec := split_ref(r)
+	for _, d := range mp {
+		ms, _ := filepath.Glob(filepath.Join(d, "man"+sec[:1], name+"."+sec+"*"))
+		if len(ms) > 0 {
+			return true
+		}
+	}
+	return false
+}
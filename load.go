@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+//the types are only for the values of constants and variables, so a package
+//that does not type check is still documented as far as it can be
+const loadMode = packages.NeedName | packages.NeedFiles | packages.NeedSyntax |
+	packages.NeedModule | packages.NeedTypes | packages.NeedTypesInfo
+
+//load resolves the package patterns, directories or .go files given on the
+//command line with the go command, so go.mod, the module path and build
+//constraints are all honored. No patterns means the current directory.
+func load(fset *token.FileSet, patterns []string) ([]*packages.Package, error) {
+	if len(patterns) == 0 {
+		patterns = []string{"."}
+	}
+	cfg := &packages.Config{
+		Mode: loadMode,
+		Fset: fset,
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		return nil, err
+	}
+	var errs []string
+	for _, p := range pkgs {
+		for _, e := range p.Errors {
+			if e.Kind == packages.TypeError {
+				continue
+			}
+			errs = append(errs, e.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.New(strings.Join(errs, "\n"))
+	}
+	return pkgs, nil
+}
+
+//ast_package adapts a loaded package to the *ast.Package go/doc wants,
+//keyed by file name like parser.ParseDir would have.
+func ast_package(fset *token.FileSet, p *packages.Package) *ast.Package {
+	files := map[string]*ast.File{}
+	for _, f := range p.Syntax {
+		files[fset.Position(f.Package).Filename] = f
+	}
+	return &ast.Package{Name: p.Name, Files: files}
+}
+
+//file_names are the names of the files of pkg, sorted, so what is read from
+//them is read in the same order every time.
+func file_names(pkg *ast.Package) []string {
+	var out []string
+	for name := range pkg.Files {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+//files are the files of pkg in the order of their names.
+func files(pkg *ast.Package) (out []*ast.File) {
+	for _, name := range file_names(pkg) {
+		out = append(out, pkg.Files[name])
+	}
+	return out
+}
+
+//test_files parses the _test.go files beside a package that belong to it or
+//to its external test package. Packages given as a list of .go files have
+//none.
+func test_files(fs *token.FileSet, p *packages.Package) (out []*ast.File) {
+	if p.Name == "main" || import_of(p) == "" || len(p.GoFiles) == 0 {
+		return nil
+	}
+	dir := filepath.Dir(p.GoFiles[0])
+	names, _ := filepath.Glob(filepath.Join(dir, "*_test.go"))
+	for _, name := range names {
+		if ok, err := build.Default.MatchFile(dir, filepath.Base(name)); err != nil || !ok {
+			continue
+		}
+		f, err := parser.ParseFile(fs, name, nil, parser.ParseComments)
+		if err != nil {
+			stderr(err)
+			continue
+		}
+		if n := f.Name.Name; n == p.Name || n == p.Name+"_test" {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+//import_of is the path a package is imported by, or "" if the go command
+//could not tell (for example, a list of .go files outside of any module).
+func import_of(p *packages.Package) string {
+	if p.PkgPath == "command-line-arguments" {
+		return ""
+	}
+	return p.PkgPath
+}
+
+//module_version is the version of the module p is in: the one the go command
+//reports for a dependency, or else the tag git describe finds for the module
+//in the repository it is in, or "".
+func module_version(p *packages.Package) string {
+	mod := p.Module
+	if mod == nil {
+		return ""
+	}
+	if v := mod.Version; v != "" && !placeholders[v] {
+		return v
+	}
+	if mod.Dir == "" {
+		return ""
+	}
+	//the tags of a module in a subdirectory of the repository start with
+	//where it is, like sub/v1.2.0
+	cmd := exec.Command("git", "rev-parse", "--show-prefix")
+	cmd.Dir = mod.Dir
+	prefix, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	pfx := strings.TrimSpace(string(prefix))
+	cmd = exec.Command("git", "describe", "--tags", "--match", pfx+"v[0-9]*")
+	cmd.Dir = mod.Dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(strings.TrimSpace(string(out)), pfx)
+}
+
+var majrx = RX("^v[0-9]+$")
+
+//cmd_name is the name go install gives the command with import path ip,
+//which skips a trailing major version element like /v2.
+func cmd_name(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	elem := path.Base(ip)
+	if majrx.MatchString(elem) && path.Dir(ip) != "." {
+		elem = path.Base(path.Dir(ip))
+	}
+	return elem
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"bytes"
-	"fmt"
 	"go/ast"
 	"go/doc"
+	"go/doc/comment"
 	"go/token"
+	"go/types"
+	"os/exec"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -23,23 +26,32 @@ func ovr_map(in []*section) map[string][]interface{} {
 }
 
 type M struct {
-	*F
+	*tree
+	out                  writer //what the page is written with
 	name, version, sec   string
+	manual, imp          string   //overrides from options, may be ""
+	opts                 *options //what the page was made with
+	parser               *comment.Parser
+	subpages             bool   //make pages for subcommands
+	subs                 []*M   //the pages for subcommands
 	descr                []byte //short description
 	sections, overd, end []*section
 	overm                map[string][]interface{}
 	refs                 []string
 	pkg                  *ast.Package
 	docs                 *doc.Package
+	fset                 *token.FileSet
+	types                *types.Package //may be nil, as may info
+	info                 *types.Info
 }
 
-func NewManPage(pkg *ast.Package, docs *doc.Package, overd []*section) *M {
+func NewManPage(pkg *ast.Package, docs *doc.Package, p *comment.Parser, o *options) *M {
 	//break up the package document, extract a short description
-	dvec := unstring([]byte(docs.Doc))
+	dvec := unstring(p, []byte(docs.Doc))
 	var fs []byte //first sentence.
 	if dvec != nil && len(dvec) > 0 {
 		if p, ok := dvec[0].([][]byte); ok && len(p) > 0 {
-			fs = p[0]
+			fs = linkrx.ReplaceAll(p[0], nil) //no room for URLs in NAME
 			//if the first paragraph is one sentence, only use it in description
 			//otherwise we leave it where it is to repeat.
 			if len(p) == 1 {
@@ -48,13 +60,19 @@ func NewManPage(pkg *ast.Package, docs *doc.Package, overd []*section) *M {
 		}
 	}
 	m := &M{
-		F:        Formatter(),
-		name:     *name,
-		version:  grep_version(pkg),
+		tree:     new_tree(),
+		out:      formats[o.format].new(o),
+		name:     o.name,
+		version:  o.version,
+		manual:   o.manual,
+		imp:      o.import_path,
+		opts:     o,
+		parser:   p,
+		subpages: o.subpages,
 		descr:    fs,
 		sections: sections(dvec),
-		overd:    overd,
-		overm:    ovr_map(overd),
+		overd:    o.overd,
+		overm:    ovr_map(o.overd),
 		pkg:      pkg,
 		docs:     docs,
 	}
@@ -68,7 +86,6 @@ func NewManPage(pkg *ast.Package, docs *doc.Package, overd []*section) *M {
 	if hs := get_section(m, "HISTORY", h); hs != nil {
 		m.end = []*section{&section{"HISTORY", hs}}
 	}
-	m.WriteString(".\\\"    Automatically generated by mango(1)")
 	return m
 }
 
@@ -84,23 +101,58 @@ func lit(x interface{}) []byte {
 	return nil
 }
 
-func grep_version(pkg *ast.Package) string {
-	if *version != "" {
-		return *version
+//blank is whether x is left out, or a string literal with only spaces in it,
+//as opposed to an expression whose value is not known.
+func blank(x ast.Expr) bool {
+	if x == nil {
+		return true
 	}
-	for _, file := range pkg.Files {
+	b, ok := x.(*ast.BasicLit)
+	return ok && len(bytes.TrimSpace(lit(b))) == 0
+}
+
+//placeholders are what a version variable is set to in the source when the
+//real version is set with go build -ldflags -X.
+var placeholders = map[string]bool{
+	"": true, "dev": true, "devel": true, "(devel)": true, "development": true,
+	"unknown": true, "snapshot": true, "0.0.0": true, "v0.0.0": true,
+}
+
+//grep_version looks for a const or var named Version, or version, whose value
+//is a constant expression, like a literal or the name of another constant.
+//A var whose value is a placeholder only counts if -X sets it, to what it is
+//set to.
+func grep_version(m *M) string {
+	path := m.docs.ImportPath
+	if m.pkg.Name == "main" {
+		path = "main" //as -ldflags -X calls it
+	}
+	d := grep_defs(m.pkg, m.info)
+	for _, file := range files(m.pkg) {
 		for _, decl := range file.Decls {
 			if g, ok := decl.(*ast.GenDecl); ok {
 				if g.Tok == token.CONST || g.Tok == token.VAR {
 					for _, s := range g.Specs {
 						if v, ok := s.(*ast.ValueSpec); ok {
 							for i, n := range v.Names {
-								if n.Name == "Version" {
-									t := v.Values[i]
-									if b, ok := t.(*ast.BasicLit); ok {
-										return string(lit(b))
+								if n.Name != "Version" && n.Name != "version" {
+									continue
+								}
+								if g.Tok == token.VAR {
+									if x, ok := m.opts.ldx[path+"."+n.Name]; ok {
+										return x
 									}
 								}
+								if i >= len(v.Values) {
+									continue
+								}
+								val := d.str(v.Values[i])
+								if g.Tok == token.VAR && placeholders[val] {
+									continue
+								}
+								if val != "" {
+									return val
+								}
 							}
 						}
 					}
@@ -180,32 +232,55 @@ func (m *M) find_refs(extras []string) {
 }
 
 func (m *M) do_header(kind string) {
-	tm := time.Now().Format("2006-01-02")
+	tm := m.opts.date
+	if tm == "" {
+		tm = commit_date(m.pkg)
+	}
+	if tm == "" {
+		tm = time.Now().Format("2006-01-02")
+	}
 	version := m.version
 	if version == "" {
 		version = tm
 	}
-	if *manual != "" {
-		kind = *manual
+	if m.manual != "" {
+		kind = m.manual
 	}
-	m.WriteString(
-		fmt.Sprintf("\n.TH \"%s\" %s \"%s\" \"version %s\" \"%s\"",
-			m.name,
-			m.sec,
-			tm,
-			version,
-			kind,
-		))
+	m.begin(m.name, m.sec, tm, version, kind)
 }
 
-func (m *M) do_name() {
-	m.section("NAME")
-	m.WriteString(m.name)
-	s := bytes.TrimSpace(m.descr)
-	if len(s) > 0 {
-		m.WriteString(" \\- ")
-		m.Write(s) //first sentence
+//commit_date is the date of the last git commit to the files of pkg, or ""
+//if they are not in git.
+func commit_date(pkg *ast.Package) string {
+	names := file_names(pkg)
+	if len(names) == 0 {
+		return ""
 	}
+	cmd := exec.Command("git", append([]string{"log", "-1", "--format=%ct", "--"}, names...)...)
+	cmd.Dir = filepath.Dir(names[0])
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return ""
+	}
+	return time.Unix(secs, 0).UTC().Format("2006-01-02")
+}
+
+//finish writes the page in its format, once all of it is extracted.
+func (m *M) finish() {
+	m.out.write(m.pg)
+}
+
+//Bytes is the page as it was written.
+func (m *M) Bytes() []byte {
+	return m.out.Bytes()
+}
+
+func (m *M) do_name() {
+	m.name_line(m.name, bytes.TrimSpace(m.descr))
 }
 
 func get_section(m *M, nm string, i int) (ps []interface{}) {
@@ -289,22 +364,8 @@ func (m *M) do_bugs() {
 	}
 }
 
-func (m *M) _seealso1(s string) {
-	m.WriteString(".BR ")
-	piv := strings.Index(s, "(")
-	m.Write(escape([]byte(s[:piv])))
-	m.WriteByte(' ')
-	m.WriteString(s[piv:])
-}
-
 func (m *M) do_see_also() {
 	if len(m.refs) > 0 {
-		m.section("SEE ALSO")
-		last := len(m.refs) - 1
-		for _, s := range m.refs[:last] {
-			m._seealso1(s)
-			m.WriteString(",\n")
-		}
-		m._seealso1(m.refs[last])
+		m.see_also(m.refs)
 	}
 }
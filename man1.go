@@ -2,8 +2,15 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"go/ast"
+	"go/doc"
+	"go/doc/comment"
+	"go/token"
+	"go/types"
 	"path"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -11,10 +18,24 @@ func doCommand(m *M) {
 	m.sec = "1"
 
 	//extract information
+	if m.name == "" {
+		m.name = cmd_name(m.docs.ImportPath)
+	}
 	if m.name == "" {
 		m.name = grep_name(m.pkg)
 	}
-	flags, descrs := grep_flags(m)
+	var flags flags
+	var descrs []string
+	for _, x := range extractors {
+		descrs = append(descrs, x(m, &flags)...)
+	}
+	flags.sort()
+	for _, c := range flags.cmds {
+		descrs = append(descrs, c.doc)
+		if m.subpages {
+			descrs = append(descrs, subname(m, c)+"(1)")
+		}
+	}
 	m.find_refs(descrs) //need name and sec first so we can ignore self references
 
 	m.do_header("User Commands")
@@ -22,69 +43,178 @@ func doCommand(m *M) {
 
 	//do synopsis
 	m.section("SYNOPSIS")
-
-	//name and discovered flags
-	m.WriteString(".B ")
-	m.WriteString(m.name)
-	for _, flag := range flags.flags {
-		m.WriteString("\n.RB [ \\-")
-		m.Write(flag[1])       //name
-		if len(flag[0]) != 0 { //varname, or "" if bool
-			m.WriteString("\n.IR ")
-			m.Write(flag[0])
-		}
-		m.WriteString(" ]")
-	}
-
-	//format extra usage flags
-	for _, w := range inverseMatch(wrx, []byte(flags.usage)) {
-		if bytes.HasPrefix(w, []byte("[")) && bytes.HasSuffix(w, []byte("]")) {
-			m.WriteString("\n.RB [ ")
-			m.Write(escape(w[1 : len(w)-1]))
-			m.WriteString(" ]")
-		} else {
-			m.WriteString("\n.B ")
-			m.Write(escape(w))
-		}
+	m.synopsis(m.name, flags.flags, flags.usage)
+	for _, c := range flags.cmds {
+		m.synopsis(m.name+" "+c.name, c.flags, c.usage)
 	}
 
 	m.do_description()
 
-	//do options
-	if x := len(flags.flags); x > 0 {
-		m.section("OPTIONS")
-		for i, flag := range flags.flags {
-			if i != 0 {
-				m.nl()
+	//do commands, with their options unless they get their own page
+	if len(flags.cmds) > 0 {
+		m.section("COMMANDS")
+		for _, c := range flags.cmds {
+			m.tag(span{kind: sp_name, text: c.name})
+			if d := short(m.parser, c.doc); len(d) > 0 {
+				m.text(d)
 			}
-			m.WriteString(".TP\n.BR \"\\-")
-			m.Write(flag[1]) //name
-			m.WriteString(" \"")
-			if len(flag[0]) != 0 { //variable name
-				m.WriteByte(' ')
-				m.Write(flag[0])
-				if len(flag[2]) != 0 { //default value
-					m.WriteString(" \" = ")
-					m.Write(flag[2])
-					m.WriteByte('"')
-				}
+			if m.subpages {
+				m.line(span{kind: sp_text, text: "See "},
+					span{kind: sp_ref, text: subname(m, c) + "(1)"},
+					span{kind: sp_text, text: "."})
+			} else if len(c.flags) > 0 {
+				m.indent()
+				m.options(c.flags)
+				m.outdent()
 			}
-			m.nl()
-			m.text(flag[3]) //help string
 		}
 	}
 
+	//do options
+	if len(flags.flags) > 0 {
+		m.section("OPTIONS")
+		m.options(flags.flags)
+	}
+
 	//put these in order, leave the rest as they come
 	m.user_sections("DIAGNOSTICS", "ENVIRONMENT", "FILES")
 	m.remaining_user_sections()
 	m.do_bugs()
 	m.do_see_also()
 	m.do_endmatter()
+
+	if m.subpages {
+		for _, c := range flags.cmds {
+			m.subs = append(m.subs, doSubcommand(m, c))
+		}
+	}
+}
+
+//doSubcommand makes the page for subcommand c of the command on page m,
+//named the way git(1) names its pages, command-subcommand(1).
+func doSubcommand(m *M, c *subcmd) *M {
+	o := *m.opts //the format and how it is written stay the same
+	o.name, o.version, o.import_path = subname(m, c), m.version, ""
+	o.overd, o.subpages = nil, false
+	s := NewManPage(m.pkg, &doc.Package{
+		Name:       m.docs.Name,
+		ImportPath: m.docs.ImportPath,
+		Doc:        c.doc,
+	}, m.parser, &o)
+	s.sec = "1"
+	descrs := []string{m.name + "(1)"}
+	for _, o := range c.flags {
+		descrs = append(descrs, string(o.help))
+	}
+	s.find_refs(descrs)
+
+	s.do_header("User Commands")
+	s.do_name()
+	s.section("SYNOPSIS")
+	s.synopsis(m.name+" "+c.name, c.flags, c.usage)
+	s.do_description()
+	if len(c.flags) > 0 {
+		s.section("OPTIONS")
+		s.options(c.flags)
+	}
+	s.user_sections("DIAGNOSTICS", "ENVIRONMENT", "FILES")
+	s.remaining_user_sections()
+	s.do_see_also()
+	s.do_endmatter()
+	s.finish()
+	return s
+}
+
+//fallback_doc describes the command with short and long if the package
+//comment does not, as when that is left to a cobra.Command.
+func (m *M) fallback_doc(short, long string) {
+	if len(m.descr) == 0 && short != "" {
+		m.descr = []byte(short)
+	}
+	if len(m.sections) == 0 && long != "" {
+		m.sections = sections(unstring(m.parser, []byte(long)))
+	}
+}
+
+//subname is the name of the page for subcommand c of the command on page m,
+//so "remote add" of git is git-remote-add.
+func subname(m *M, c *subcmd) string {
+	return m.name + "-" + strings.Replace(c.name, " ", "-", -1)
+}
+
+//short is the first sentence of a doc comment.
+func short(p *comment.Parser, doc string) []byte {
+	ps := unstring(p, []byte(doc))
+	if len(ps) > 0 {
+		if p, ok := ps[0].([][]byte); ok && len(p) > 0 {
+			return bytes.TrimSpace(p[0])
+		}
+	}
+	return nil
+}
+
+//synopsis writes one synopsis line for the command name, its flags and
+//additional usage.
+func (m *M) synopsis(name string, fl []*opt, usage string) {
+	//name and discovered flags
+	s := []span{{kind: sp_name, text: name}}
+	for _, o := range fl {
+		s = append(s, span{kind: sp_text, text: " ["})
+		for i, f := range o.forms() {
+			if i != 0 {
+				s = append(s, span{kind: sp_text, text: " | "})
+			}
+			s = append(s, span{kind: sp_flag, text: f})
+		}
+		if len(o.varname) != 0 { //"" if bool
+			s = append(s, span{kind: sp_text, text: " "},
+				span{kind: sp_arg, text: string(o.varname)})
+		}
+		s = append(s, span{kind: sp_text, text: "]"})
+	}
+
+	//format extra usage flags
+	for _, w := range strings.Fields(usage) {
+		kind := sp_arg
+		if switchrx.MatchString(strings.Trim(w, "[]")) {
+			kind = sp_flag
+		}
+		if strings.HasPrefix(w, "[") && strings.HasSuffix(w, "]") {
+			s = append(s, span{kind: sp_text, text: " ["},
+				span{kind: kind, text: w[1 : len(w)-1]},
+				span{kind: sp_text, text: "]"})
+		} else {
+			s = append(s, span{kind: sp_text, text: " "}, span{kind: kind, text: w})
+		}
+	}
+	m.line(s...)
+}
+
+//options writes a tagged paragraph for each flag.
+func (m *M) options(fl []*opt) {
+	for _, o := range fl {
+		var s []span
+		for i, f := range o.forms() {
+			if i != 0 {
+				s = append(s, span{kind: sp_text, text: ", "})
+			}
+			s = append(s, span{kind: sp_flag, text: f})
+		}
+		if len(o.varname) != 0 {
+			s = append(s, span{kind: sp_text, text: " "},
+				span{kind: sp_arg, text: string(o.varname)})
+			if len(o.def) != 0 {
+				s = append(s, span{kind: sp_text, text: " = " + string(o.def)})
+			}
+		}
+		m.option(o, s...)
+		m.text(o.help)
+	}
 }
 
 func grep_name(p *ast.Package) string {
-	for name, file := range p.Files {
-		for _, decl := range file.Decls {
+	for _, name := range file_names(p) {
+		for _, decl := range p.Files[name].Decls {
 			if f, ok := decl.(*ast.FuncDecl); ok {
 				if f.Name.Name == "main" {
 					str := path.Base(name)
@@ -97,77 +227,607 @@ func grep_name(p *ast.Package) string {
 	panic("issue 65")
 }
 
-//BUG(jmf): Need to special case flag.Var to make it format properly.
-
 //allow addition of more option info with a line in the comments above main
 //matching the below regex
 var usrx = RX("^[ \t]*Usage:([ ]+%name)?[ ]+(%flags?[ ]+)?")
 
+//opt is one command line option.
+type opt struct {
+	varname []byte //names the argument, nil for a switch
+	name    []byte
+	def     []byte //default value, may be nil
+	help    []byte
+	nohelp  bool      //the help is left out or blank, not only unknown
+	dashes  string    //"-", or "--" for the GNU style of pflag and the like
+	aka     []string  //other names for it as typed, like -v for -verbose
+	pos     token.Pos //where it is defined
+}
+
+//forms are the ways the option may be typed, shortest first.
+func (o *opt) forms() (out []string) {
+	out = append(out, o.aka...)
+	d := o.dashes
+	if d == "" {
+		d = "-"
+	}
+	return append(out, d+string(o.name))
+}
+
+//alias makes o and p, which are the same option by another name, into one,
+//named by the longer of the two, with the longer help, as the other is often
+//only "the same as" this one.
+func (o *opt) alias(p *opt) {
+	if len(p.name) > len(o.name) {
+		o.name, p.name = p.name, o.name
+		o.dashes, p.dashes = p.dashes, o.dashes
+	}
+	if len(p.help) > len(o.help) {
+		o.help = p.help
+	}
+	o.nohelp = o.nohelp && p.nohelp
+	d := p.dashes
+	if d == "" {
+		d = "-"
+	}
+	o.aka = append(o.aka, p.aka...)
+	o.aka = append(o.aka, d+string(p.name))
+	sort.SliceStable(o.aka, func(i, j int) bool {
+		return len(o.aka[i]) < len(o.aka[j])
+	})
+}
+
 type flags struct {
 	usage string
-	flags [][4][]byte //varname, name, default, help
+	flags []*opt
+	cmds  []*subcmd //by name
+}
+
+//subcmd is a subcommand with its own flags, like those made with
+//flag.NewFlagSet.
+type subcmd struct {
+	name  string
+	doc   string //of the function that makes its FlagSet, say
+	usage string
+	flags []*opt
+}
+
+//An extractor adds what it finds of a command's usage, flags and subcommands
+//in the package of m to fl, and returns any text that may hold references.
+type extractor func(m *M, fl *flags) []string
+
+var extractors = []extractor{grep_flags, grep_cobra, grep_cli}
+
+//sort puts options and subcommands in order, since files are visited in
+//no particular order.
+func (fl *flags) sort() {
+	sort_flags(fl.flags)
+	for _, c := range fl.cmds {
+		sort_flags(c.flags)
+	}
+	sort.SliceStable(fl.cmds, func(i, j int) bool {
+		return fl.cmds[i].name < fl.cmds[j].name
+	})
+}
+
+func sort_flags(fl []*opt) {
+	sort.SliceStable(fl, func(i, j int) bool {
+		return bytes.Compare(fl[i].name, fl[j].name) < 0
+	})
+}
+
+//pkg_refs is how one file refers to a package, which may be renamed or
+//dot imported.
+type pkg_refs struct {
+	names map[string]bool //local names of the package
+	dot   bool            //imported with .
+}
+
+//grep_refs finds how file refers to the package imported by any of paths,
+//which are all taken to be the same package; its default name is the last
+//element of the first.
+func grep_refs(file *ast.File, paths ...string) *pkg_refs {
+	r := &pkg_refs{names: map[string]bool{}}
+	for _, imp := range file.Imports {
+		p, _ := strconv.Unquote(imp.Path.Value)
+		found := false
+		for _, want := range paths {
+			found = found || p == want
+		}
+		if !found {
+			continue
+		}
+		switch {
+		case imp.Name == nil:
+			r.names[path.Base(paths[0])] = true
+		case imp.Name.Name == ".":
+			r.dot = true
+		case imp.Name.Name != "_":
+			r.names[imp.Name.Name] = true
+		}
+	}
+	return r
+}
+
+//none reports whether the file does not import the package at all.
+func (r *pkg_refs) none() bool {
+	return len(r.names) == 0 && !r.dot
+}
+
+//is reports whether x names something in the package, like pkg.Name, or Name
+//if it was dot imported, returning that name.
+func (r *pkg_refs) is(x ast.Expr) (string, bool) {
+	switch t := x.(type) {
+	case *ast.SelectorExpr:
+		if id, ok := t.X.(*ast.Ident); ok && r.names[id.Name] {
+			return t.Sel.Name, true
+		}
+	case *ast.Ident:
+		if r.dot {
+			return t.Name, true
+		}
+	case *ast.StarExpr:
+		return r.is(t.X)
+	}
+	return "", false
+}
+
+//flag_fn is the name of the function in package flag x calls, or "".
+func flag_fn(x ast.Expr, r *pkg_refs) string {
+	c, ok := x.(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	fn, _ := r.is(c.Fun)
+	return fn
+}
+
+//scope maps the names of variables holding a flag.FlagSet to the subcommand
+//it is for, or to nil for sets that stand in for the command line.
+type scope map[string]*subcmd
+
+//flag_def reports whether x is a call that defines a flag, returning the call,
+//the name of the function called, and the subcommand whose FlagSet it is
+//called on, if any.
+func flag_def(x ast.Expr, r *pkg_refs, sc scope) (c *ast.CallExpr, fn string, set *subcmd) {
+	c, ok := x.(*ast.CallExpr)
+	if !ok {
+		return nil, "", nil
+	}
+	fn = flag_fn(x, r)
+	if f, ok := c.Fun.(*ast.SelectorExpr); ok && fn == "" {
+		//a FlagSet method; flag.CommandLine is the command line
+		if cl, ok := f.X.(*ast.SelectorExpr); ok && cl.Sel.Name == "CommandLine" {
+			if id, ok := cl.X.(*ast.Ident); ok && r.names[id.Name] {
+				fn = f.Sel.Name
+			}
+		} else if s, ok := sc[var_name(f.X)]; ok {
+			fn, set = f.Sel.Name, s
+		}
+	}
+	switch fn {
+	case "Bool", "Duration", "Float64", "Int", "Int64", "String", "Uint",
+		"Uint64",
+		"BoolVar", "DurationVar", "Float64Var", "IntVar", "Int64Var",
+		"StringVar", "UintVar", "Uint64Var",
+		"Var", "TextVar", "Func", "BoolFunc":
+		return c, fn, set
+	}
+	return nil, "", nil
+}
+
+//bound reports whether the flag function fn binds a variable through its
+//first argument, rather than returning a pointer to a new one.
+func bound(fn string) bool {
+	return strings.HasSuffix(fn, "Var")
+}
+
+//statement reports whether the flag function fn returns nothing, so it is
+//called as a statement rather than assigned.
+func statement(fn string) bool {
+	return bound(fn) || fn == "Func" || fn == "BoolFunc"
+}
+
+//flag_args splits the arguments to flag function fn into the bound value,
+//name, default and usage; value and default may be nil.
+func flag_args(fn string, args []ast.Expr) (val, name, def, usage ast.Expr) {
+	n := 3
+	if fn == "TextVar" || (bound(fn) && fn != "Var") {
+		n = 4
+	}
+	if len(args) != n {
+		fatal("Could not parse flags.")
+	}
+	switch fn {
+	case "Var":
+		return args[0], args[1], nil, args[2]
+	case "Func", "BoolFunc":
+		return nil, args[0], nil, args[1]
+	}
+	if bound(fn) {
+		return args[0], args[1], args[2], args[3]
+	}
+	return nil, args[0], args[1], args[2]
+}
+
+//var_name is the name of the variable x refers to, looking through & and
+//field selections, or "" if x is anything else.
+func var_name(x ast.Expr) string {
+	switch t := x.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	case *ast.UnaryExpr:
+		return var_name(t.X)
+	case *ast.StarExpr:
+		return var_name(t.X)
+	case *ast.ParenExpr:
+		return var_name(t.X)
+	}
+	return ""
+}
+
+//var_decl is what is known of a variable's type from where it is declared;
+//either may be nil.
+type var_decl struct {
+	typ, val ast.Expr
+}
+
+//flag_scan is what grep_flags learns about a package while it looks for
+//flag definitions.
+type flag_scan struct {
+	out    *flags
+	descrs []string
+	seen   map[string]bool          //flag names, in case one is found twice
+	bound  map[string]*opt          //options by the variable they set
+	vars   map[string]*var_decl     //variables a flag.Var may be bound to
+	types  map[string]bool          //names of types declared in the package
+	strs   map[string]*ast.FuncDecl //String methods, by receiver type name
+	cmds   map[string]*subcmd       //subcommands by name
+	sets   scope                    //package level FlagSets
+}
+
+//new_set reports whether x makes a FlagSet, returning the subcommand it is
+//for; that is nil if its name is not a literal, as in
+//flag.NewFlagSet(os.Args[0], ...), which is a stand in for the command line.
+func (s *flag_scan) new_set(x ast.Expr, r *pkg_refs, doc *ast.CommentGroup) (set *subcmd, ok bool) {
+	if flag_fn(x, r) != "NewFlagSet" {
+		return nil, false
+	}
+	c := x.(*ast.CallExpr)
+	if len(c.Args) == 0 {
+		return nil, true
+	}
+	name := lit(c.Args[0])
+	if name == nil {
+		return nil, true
+	}
+	if set = s.cmds[string(name)]; set == nil {
+		set = &subcmd{name: string(name)}
+		s.cmds[set.name] = set
+	}
+	if doc != nil && set.doc == "" {
+		//a usage line is for the synopsis, not the description
+		for _, line := range strings.SplitAfter(doc.Text(), "\n") {
+			if u := usrx.FindStringIndex(line); u != nil && set.usage == "" {
+				set.usage = strings.TrimSpace(line[u[1]:])
+				continue
+			}
+			set.doc += line
+		}
+	}
+	return set, true
+}
+
+//type_of names the type of the expression x, declared with type typ, if
+//it can tell without type checking.
+func (s *flag_scan) type_of(typ, x ast.Expr) string {
+	if typ != nil {
+		return var_name(typ)
+	}
+	switch t := x.(type) {
+	case *ast.Ident:
+		if v := s.vars[t.Name]; v != nil && v.val != x {
+			return s.type_of(v.typ, v.val)
+		}
+	case *ast.CompositeLit:
+		return var_name(t.Type)
+	case *ast.UnaryExpr:
+		return s.type_of(nil, t.X)
+	case *ast.ParenExpr:
+		return s.type_of(nil, t.X)
+	case *ast.CallExpr:
+		//new(T) or a conversion T(x)
+		if id, ok := t.Fun.(*ast.Ident); ok && id.Name == "new" && len(t.Args) == 1 {
+			return var_name(t.Args[0])
+		}
+		if n := var_name(t.Fun); s.types[n] {
+			return n
+		}
+	}
+	return ""
 }
 
-//BUG(jmf): No way to group short/long name option pairs.
+//value_default is the default of a flag.Value bound to x, if the String
+//method of its type returns a literal, or converts the receiver and x was
+//initialized with a literal.
+func (s *flag_scan) value_default(x ast.Expr, typ string) []byte {
+	f := s.strs[typ]
+	if f == nil || len(f.Body.List) != 1 {
+		return nil
+	}
+	ret, ok := f.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return nil
+	}
+	if b := lit(ret.Results[0]); b != nil {
+		return b
+	}
+	v := s.vars[var_name(x)]
+	if v == nil {
+		return nil
+	}
+	switch t := v.val.(type) {
+	case *ast.BasicLit:
+		return lit(t)
+	case *ast.CallExpr:
+		if len(t.Args) == 1 && var_name(t.Fun) == typ {
+			return lit(t.Args[0])
+		}
+	}
+	return nil
+}
 
-func grep_flags(m *M) (a flags, d []string) {
-	out := flags{"", make([][4][]byte, 0, 8)}
-	descrs := []string{}
+//placeholder makes an argument name out of a type name, so a stringsFlag or
+//LevelValue stands for strings or level.
+func placeholder(typ string) string {
+	for _, sfx := range []string{"Flag", "Value"} {
+		if len(typ) > len(sfx) && strings.HasSuffix(typ, sfx) {
+			typ = typ[:len(typ)-len(sfx)]
+			break
+		}
+	}
+	return strings.ToLower(typ[:1]) + typ[1:]
+}
+
+//flag.Type(n, def, dscr) assigned to N |-> (N, n, def, dscr)
+//flag.TypeVar(&N, n, def, dscr) |-> (N, n, def, dscr)
+//flag.Var(&N, n, dscr) |-> (type of N, n, N.String(), dscr)
+func (s *flag_scan) add(varname string, c *ast.CallExpr, fn string, set *subcmd) {
+	val, nm, def, usage := flag_args(fn, c.Args)
+	name := lit(nm)
+	key := string(name)
+	if set != nil {
+		key = set.name + " " + key
+	}
+	if name == nil || s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+
+	//package up flag info
+	descr := lit(usage)
+	s.descrs = append(s.descrs, string(descr))
+	o := &opt{
+		varname: []byte(varname),
+		name:    name,
+		def:     lit(def),
+		help:    descr,
+		nohelp:  blank(usage),
+		pos:     c.Pos(),
+	}
+	if val != nil {
+		o.varname = []byte(var_name(val))
+	} else if varname == "" {
+		o.varname = []byte(strings.ToLower(fn))
+	}
+	switch fn {
+	case "Bool", "BoolVar", "BoolFunc":
+		o.varname = nil
+		o.def = nil
+	case "Func":
+		o.varname = []byte("value")
+	case "Var", "TextVar":
+		if typ := s.type_of(nil, val); typ != "" {
+			o.varname = []byte(placeholder(typ))
+			if fn == "Var" {
+				o.def = s.value_default(val, typ)
+			}
+		}
+	}
+
+	//flags bound to the same variable are one option by several names,
+	//whether it is bound with TypeVar or is what Type returned
+	bkey := varname
+	if val != nil {
+		bkey = types.ExprString(val)
+	}
+	if bkey != "" {
+		if set != nil {
+			bkey = set.name + " " + bkey
+		}
+		if prev := s.bound[bkey]; prev != nil {
+			prev.alias(o)
+			return
+		}
+		s.bound[bkey] = o
+	}
+
+	if set != nil {
+		set.flags = append(set.flags, o)
+	} else {
+		s.out.flags = append(s.out.flags, o)
+	}
+}
+
+//grep_flags is the extractor for package flag.
+func grep_flags(m *M, fl *flags) []string {
+	s := &flag_scan{
+		out:   fl,
+		seen:  map[string]bool{},
+		bound: map[string]*opt{},
+		vars:  map[string]*var_decl{},
+		types: map[string]bool{},
+		strs:  map[string]*ast.FuncDecl{},
+		cmds:  map[string]*subcmd{},
+		sets:  scope{},
+	}
 
 	//see if there's an additional usage string
 	for _, fnc := range m.docs.Funcs {
 		if fnc.Name == "main" {
 			for _, line := range strings.Split(fnc.Doc, "\n") {
-				if s := usrx.FindStringIndex(line); s != nil {
-					out.usage = line[s[1]:]
+				if u := usrx.FindStringIndex(line); u != nil {
+					fl.usage = line[u[1]:]
 					break //only going to be one
 				}
 			}
 		}
 	}
 
-	//find all var N = flag.Var(n, def, dscr) |-> (N, n, def, dscr)
-	for _, Var := range m.docs.Vars {
-		for _, gVal := range Var.Decl.Specs {
-			Val := gVal.(*ast.ValueSpec)
-			for i, val := range Val.Values {
-				if c, ok := val.(*ast.CallExpr); ok {
-					if s, ok := c.Fun.(*ast.SelectorExpr); ok {
-						if id, ok := s.X.(*ast.Ident); ok && id.Name == "flag" {
-							if len(c.Args) != 3 {
-								fatal("Could not parse flags.")
+	//package level declarations, and the functions and imports of each
+	//file, since each file may import flag differently
+	funcs := map[string]*ast.FuncDecl{}
+	refs := map[*ast.FuncDecl]*pkg_refs{}
+	var roots []*ast.FuncDecl
+	for _, file := range files(m.pkg) {
+		r := grep_refs(file, "flag")
+		for _, decl := range file.Decls {
+			switch d := decl.(type) {
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch sp := spec.(type) {
+					case *ast.TypeSpec:
+						s.types[sp.Name.Name] = true
+					case *ast.ValueSpec:
+						if d.Tok == token.VAR {
+							doc := sp.Doc
+							if doc == nil {
+								doc = d.Doc
 							}
+							s.spec(sp, r, s.sets, doc)
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				if d.Body == nil {
+					continue
+				}
+				if d.Recv != nil {
+					if d.Name.Name == "String" && len(d.Recv.List) == 1 {
+						s.strs[var_name(d.Recv.List[0].Type)] = d
+					}
+					continue
+				}
+				refs[d] = r
+				switch d.Name.Name {
+				case "init", "main":
+					roots = append(roots, d)
+				default:
+					funcs[d.Name.Name] = d
+				}
+			}
+		}
+	}
 
-							//package up flag info
-							descr := lit(c.Args[2])
-							descrs = append(descrs, string(descr))
-							group := [...][]byte{
-								[]byte(Val.Names[i].Name),
-								lit(c.Args[0]),
-								lit(c.Args[1]),
-								descr,
-							}
-							if s.Sel.Name == "Bool" {
-								group[0] = nil
-								group[2] = nil
-							}
+	//flags defined in init and main and any function they call, which may
+	//be handed a FlagSet to define flags in
+	walked := map[string]bool{}
+	var walk func(f *ast.FuncDecl, sc scope)
+	walk = func(f *ast.FuncDecl, sc scope) {
+		key := fmt.Sprint(f.Pos(), sc)
+		if walked[key] {
+			return
+		}
+		walked[key] = true
+		r := refs[f]
+		var doc *ast.CommentGroup
+		switch f.Name.Name {
+		case "init", "main":
+		default:
+			doc = f.Doc
+		}
+		ast.Inspect(f.Body, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.AssignStmt: //N := flag.Type(...)
+				if len(x.Lhs) != len(x.Rhs) {
+					break
+				}
+				for i, rhs := range x.Rhs {
+					lhs := var_name(x.Lhs[i])
+					if c, fn, set := flag_def(rhs, r, sc); c != nil && !statement(fn) {
+						s.add(lhs, c, fn, set)
+					} else if set, ok := s.new_set(rhs, r, doc); ok {
+						sc[lhs] = set
+					} else if x.Tok == token.DEFINE {
+						s.vars[lhs] = &var_decl{nil, rhs}
+					}
+				}
+			case *ast.ValueSpec: //var N = flag.Type(...)
+				s.spec(x, r, sc, doc)
+			case *ast.CallExpr: //flag.TypeVar(...), or a result ignored
+				if c, fn, set := flag_def(x, r, sc); c != nil {
+					s.add("", c, fn, set)
+				} else if id, ok := x.Fun.(*ast.Ident); ok && funcs[id.Name] != nil {
+					walk(funcs[id.Name], s.pass(funcs[id.Name], x.Args, sc))
+				}
+			}
+			return true
+		})
+	}
+	for _, f := range roots {
+		walk(f, s.local())
+	}
 
-							//muss with slice
-							if c := cap(out.flags); len(out.flags) == c {
-								new := make([][4][]byte, c, c+c/2)
-								copy(new, out.flags)
-								out.flags = new
-							}
-							end := len(out.flags)
-							out.flags = out.flags[:end+1]
+	for _, c := range s.cmds {
+		fl.cmds = append(fl.cmds, c)
+	}
+	return s.descrs
+}
 
-							out.flags[end] = group
-						}
-					}
+//local is a scope for a function body, starting with the package FlagSets.
+func (s *flag_scan) local() scope {
+	sc := scope{}
+	for k, v := range s.sets {
+		sc[k] = v
+	}
+	return sc
+}
+
+//pass is the scope f is called in with args, where any FlagSet passed in
+//is known by the name of its parameter.
+func (s *flag_scan) pass(f *ast.FuncDecl, args []ast.Expr, sc scope) scope {
+	callee := s.local()
+	i := 0
+	for _, p := range f.Type.Params.List {
+		for _, n := range p.Names {
+			if i < len(args) {
+				if set, ok := sc[var_name(args[i])]; ok {
+					callee[n.Name] = set
 				}
 			}
+			i++
 		}
 	}
+	return callee
+}
 
-	return out, descrs
+//spec records the variables declared by sp and any flags or FlagSets they
+//are assigned.
+func (s *flag_scan) spec(sp *ast.ValueSpec, r *pkg_refs, sc scope, doc *ast.CommentGroup) {
+	for i, n := range sp.Names {
+		v := &var_decl{typ: sp.Type}
+		if len(sp.Values) == len(sp.Names) {
+			v.val = sp.Values[i]
+			if c, fn, set := flag_def(v.val, r, sc); c != nil && !statement(fn) {
+				s.add(n.Name, c, fn, set)
+				continue
+			}
+			if set, ok := s.new_set(v.val, r, doc); ok {
+				sc[n.Name] = set
+				continue
+			}
+		}
+		s.vars[n.Name] = v
+	}
 }
@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestAlias(t *testing.T) {
+	for _, c := range []struct {
+		a, b       *opt
+		name, help string
+		aka        []string
+	}{
+		//the longer name has the shorter help
+		{&opt{name: []byte("o"), help: []byte("Write the pages into the given directory")},
+			&opt{name: []byte("outdir"), help: []byte("The same as -o")},
+			"outdir", "Write the pages into the given directory", []string{"-o"}},
+		{&opt{name: []byte("outdir"), help: []byte("The same as -o")},
+			&opt{name: []byte("o"), help: []byte("Write the pages into the given directory")},
+			"outdir", "Write the pages into the given directory", []string{"-o"}},
+		{&opt{name: []byte("v"), help: []byte("")},
+			&opt{name: []byte("verbose"), help: []byte("Be verbose"), dashes: "--"},
+			"verbose", "Be verbose", []string{"-v"}},
+	} {
+		c.a.alias(c.b)
+		if string(c.a.name) != c.name || string(c.a.help) != c.help {
+			t.Errorf("got %s with %q, want %s with %q", c.a.name, c.a.help, c.name, c.help)
+		}
+		if len(c.a.aka) != len(c.aka) || c.a.aka[0] != c.aka[0] {
+			t.Errorf("%s: got the other names %q, want %q", c.name, c.a.aka, c.aka)
+		}
+	}
+}
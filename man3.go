@@ -1,13 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"go/ast"
 	"go/doc"
+	"go/printer"
 	"go/token"
+	"go/types"
+	"path"
+	"strconv"
+	"strings"
 )
 
+//type_type is the spec of t, which go/doc gives a declaration of its own even
+//if it was declared in a group.
 func type_type(t *doc.Type) *ast.TypeSpec {
-	print(len(t.Decl.Specs)) //XXX remove if always 1
 	return t.Decl.Specs[0].(*ast.TypeSpec)
 }
 
@@ -28,59 +35,55 @@ func doPackage(m *M) {
 
 	//do synopsis
 	m.section("SYNOPSIS")
-	m.WriteString(".B import ")
-	if *import_path != "" {
-		m.WriteString(m.name)
-		m.WriteByte(' ')
+	ip := m.imp
+	if ip == "" {
+		ip = m.docs.ImportPath
 	}
-	m.WriteString("\\*(lq")
-	if *import_path != "" {
-		m.WriteString(*import_path)
-	} else {
-		m.WriteString(m.name)
+	if ip == "" {
+		ip = m.name
 	}
-	m.WriteString("\\(rq\n.sp")
+	imp := "import "
+	if path.Base(ip) != m.name {
+		imp += m.name + " "
+	}
+	m.line(span{kind: sp_go, text: imp + strconv.Quote(ip)})
+	m.PP()
 
 	//build TOC
-	if len(m.docs.Consts) > 0 { //TODO see bug above
-		m.WriteString("\n.B Constants\n.sp 0")
+	if len(m.docs.Consts) > 0 {
+		m.line(span{kind: sp_name, text: "Constants", link: "CONSTANTS"})
 	}
-	if len(m.docs.Vars) > 0 { //TODO see bug above
-		m.WriteString("\n.B Variables\n.sp 0")
+	if len(m.docs.Vars) > 0 {
+		m.line(span{kind: sp_name, text: "Variables", link: "VARIABLES"})
 	}
 	for _, f := range m.docs.Funcs {
-		m.WriteString("\n.RB \"func \" ")
-		m.WriteString(f.Name)
-		m.WriteString("\n.sp 0")
+		m.line(span{kind: sp_text, text: "func "},
+			span{kind: sp_name, text: f.Name, link: f.Name})
 	}
 	for _, t := range m.docs.Types {
-		m.WriteString("\n.RB \"type \" ")
-		m.WriteString(type_name(t))
-		m.WriteString("\n.sp 0")
+		name := type_name(t)
+		m.line(span{kind: sp_text, text: "type "},
+			span{kind: sp_name, text: name, link: name})
 		ind := len(t.Funcs) > 0 || len(t.Methods) > 0
 		if ind {
-			m.WriteString("\n.RS")
+			m.indent()
 		}
 		for _, f := range t.Funcs {
 			if !ast.IsExported(f.Name) {
 				continue
 			}
-			m.WriteString("\n.RB \"func \" ")
-			m.WriteString(f.Name)
-			m.WriteString("\n.sp 0")
+			m.line(span{kind: sp_text, text: "func "},
+				span{kind: sp_name, text: f.Name, link: f.Name})
 		}
 		for _, mt := range t.Methods {
 			if !ast.IsExported(mt.Name) {
 				continue
 			}
-			m.WriteString("\n.RB \"func (")
-			m.WriteString(mt.Recv)
-			m.WriteString(") \" ")
-			m.WriteString(mt.Name)
-			m.WriteString("\n.sp 0")
+			m.line(span{kind: sp_text, text: "func (" + mt.Recv + ") "},
+				span{kind: sp_name, text: mt.Name, link: name + "." + mt.Name})
 		}
 		if ind {
-			m.WriteString("\n.RE")
+			m.outdent()
 		}
 	}
 
@@ -100,343 +103,438 @@ func doPackage(m *M) {
 
 	if len(m.docs.Funcs) > 0 {
 		m.section("FUNCTIONS")
-		Funcs(m.F, m.docs.Funcs)
+		Funcs(m, m.docs.Funcs)
 	}
 
 	if len(m.docs.Types) > 0 {
 		m.section("TYPES")
 	}
 	for _, t := range m.docs.Types {
+		ts := type_type(t)
 		name := type_name(t)
-		m.nl()
-		m.WriteString(".SS \"")
-		m.WriteString(name)
-		m.WriteString("\"\n.B type ")
-		m.WriteString(name)
-		m.WriteByte(' ')
+		m.subsection(name)
+		m.anchor(name)
+		head := "type " + name + tparams(ts.TypeParams) + " "
+		if ts.Assign.IsValid() {
+			head += "= "
+		}
 		composite, unexported := false, false
 		kind := "fields."
-		switch typ := type_type(t).Type.(type) {
+		switch typ := ts.Type.(type) {
 		case *ast.InterfaceType:
-			m.WriteString("interface {\n.RS")
-			unexported = methods(m.F, typ.Methods, false)
+			m.line(span{kind: sp_go, text: head + "interface {"})
+			m.indent()
+			unexported = methods(m, typ.Methods)
 			composite = true
 			kind = "methods."
 		case *ast.StructType:
-			m.WriteString("struct {\n.RS\n")
-			unexported = fields(m.F, typ.Fields, "\n")
+			m.line(span{kind: sp_go, text: head + "struct {"})
+			m.indent()
+			unexported = fields(m, typ.Fields)
 			composite = true
 		default:
-			m.WriteString(typesigs(type_type(t).Type))
+			m.line(span{kind: sp_go, text: head + typesigs(ts.Type)})
 		}
 		if composite {
-			m.nl()
 			if unexported {
-				m.WriteString(".sp 0\n.B //contains unexported ")
-				m.WriteString(kind)
-				m.WriteByte('\n')
+				m.line(span{kind: sp_go, text: "//contains unexported " + kind})
 			}
-			m.WriteString(".RE\n.B }")
+			m.outdent()
+			m.line(span{kind: sp_go, text: "}"})
 		}
 		l := len(t.Doc) + len(t.Consts) + len(t.Vars) + len(t.Funcs)
 		l += len(t.Methods)
 		if l > 0 {
 			m.PP()
 
-			genDoc(m.F, t.Doc)
+			genDoc(m, t.Doc)
+			Examples(m, t.Examples)
+			if len(t.Doc)+len(t.Examples) > 0 && len(t.Consts)+len(t.Vars) > 0 {
+				m.PP()
+			}
 
 			Values(m, t.Consts)
+			if len(t.Consts) > 0 && len(t.Vars) > 0 {
+				m.PP()
+			}
 			Values(m, t.Vars)
 
-			Funcs(m.F, t.Funcs)
-			Funcs(m.F, t.Methods)
+			Funcs(m, t.Funcs)
+			Funcs(m, t.Methods)
 		}
 	}
 
+	if len(m.docs.Examples) > 0 {
+		m.section("EXAMPLES")
+		Examples(m, m.docs.Examples)
+	}
+
 	m.do_bugs()
 	m.do_see_also()
 	m.do_endmatter()
 }
 
-func genDoc(m *F, s string) {
+func genDoc(m *M, s string) {
 	if len(s) == 0 {
 		return
 	}
-	m.paras(unstring([]byte(s)))
+	m.paras(unstring(m.parser, []byte(s)))
 }
 
-//BUG(jmf): Does not render RHS of consts or vars for section 3.
-
 func Values(m *M, V []*doc.Value) {
 	for i, v := range V {
-		genDoc(m.F, v.Doc)
-		m.PP()
-		m.WriteString(".B ")
-		d := v.Decl
-		switch d.Tok {
-		case token.CONST:
-			m.WriteString("const ")
-		case token.VAR:
-			m.WriteString("var ")
+		if i != 0 {
+			m.PP()
 		}
+		if len(v.Doc) > 0 {
+			genDoc(m, v.Doc)
+			m.PP()
+		}
+		d := v.Decl
+		kw := d.Tok.String() + " "
 		multiple := len(d.Specs) != 1
 		if multiple {
-			m.WriteString("(\n.RS")
+			m.line(span{kind: sp_go, text: kw + "("})
+			m.indent()
+			kw = ""
 		}
 		for _, sp := range d.Specs {
-			m.nl()
-			m.WriteString(".B ")
 			vs := sp.(*ast.ValueSpec)
+			var names, vals, consts []string
 			for k, n := range vs.Names {
 				if !ast.IsExported(n.Name) {
 					continue
 				}
-				m.WriteString(n.Name)
-				if k != len(vs.Names)-1 {
-					m.WriteString(", ")
-				} else {
-					m.WriteByte(' ')
+				names = append(names, n.Name)
+				if len(vs.Values) == len(vs.Names) {
+					vals = append(vals, expr(vs.Values[k]))
+				}
+				if c := m.constant(n); c != "" {
+					consts = append(consts, c)
 				}
 			}
-			typesig(m, vs.Type) //okay if nil
-			m.nl()
-			m.WriteString(".sp 0\n")
-			//TODO if vs.Type==nil can sometimes deduce type from vs.Values
-			//TODO print values when possible
+			if len(names) == 0 {
+				continue
+			}
+			//x, y = f() only makes sense with both names
+			if len(vs.Values) == 1 && len(vs.Names) > 1 && len(names) == len(vs.Names) {
+				vals = []string{expr(vs.Values[0])}
+			}
+
+			for _, n := range names {
+				m.anchor(n)
+			}
+			l := kw + strings.Join(names, ", ")
+			if vs.Type != nil {
+				l += " " + typesigs(vs.Type)
+			} else if t := m.inferred(vs.Names[0]); t != "" {
+				l += " " + t
+			}
+			if len(vals) > 0 {
+				l += " = " + strings.Join(vals, ", ")
+			}
+			//say what iota and constant expressions come out as
+			if len(consts) > 0 && !literal(vs.Values) {
+				l += " // " + strings.Join(consts, ", ")
+			}
+			m.line(span{kind: sp_go, text: l})
 		}
 		if multiple {
-			m.WriteString(".RE\n.B )")
+			m.outdent()
+			m.line(span{kind: sp_go, text: ")"})
+		}
+	}
+}
+
+//expr is x on one line, with the bodies of composite and function literals
+//left out.
+func expr(x ast.Expr) string {
+	return strings.Replace(types.ExprString(x), "…", "...", -1)
+}
+
+//constant is the value of the constant n names, if the package type checked.
+func (m *M) constant(n *ast.Ident) string {
+	if m.info == nil {
+		return ""
+	}
+	if c, ok := m.info.Defs[n].(*types.Const); ok {
+		return c.Val().String()
+	}
+	return ""
+}
+
+//inferred is the type of n when its declaration leaves it out, or "" if
+//that is unknown or an untyped constant, where the value says as much.
+func (m *M) inferred(n *ast.Ident) string {
+	if m.info == nil {
+		return ""
+	}
+	obj := m.info.Defs[n]
+	if obj == nil {
+		return ""
+	}
+	if b, ok := obj.Type().(*types.Basic); ok && b.Info()&types.IsUntyped != 0 {
+		return ""
+	}
+	return types.TypeString(obj.Type(), types.RelativeTo(m.types))
+}
+
+//literal is true if every value is written out as a literal, so there is
+//nothing to add by giving its value.
+func literal(vals []ast.Expr) bool {
+	if len(vals) == 0 {
+		return false
+	}
+	for _, v := range vals {
+		if u, ok := v.(*ast.UnaryExpr); ok && u.Op == token.SUB {
+			v = u.X
 		}
-		if i != len(V)-1 {
-			m.WriteString("\n.sp 0\n")
+		if _, ok := v.(*ast.BasicLit); !ok {
+			return false
 		}
 	}
+	return true
 }
 
-func Funcs(m *F, F []*doc.Func) {
+func Funcs(m *M, F []*doc.Func) {
 	for _, f := range F {
 		if !ast.IsExported(f.Name) {
 			continue
 		}
 		m.PP()
-		m.BR.B("func ")
+		s := []span{{kind: sp_go, text: "func "}}
+		id := f.Name
 		if f.Recv != "" {
-			m.BR.B("(")
-			m.BR.B(f.Recv)
-			m.BR.B(") ")
+			s = append(s, span{kind: sp_go, text: "(" + f.Recv + ") "})
+			id = recv_name(f.Recv) + "." + f.Name
 		}
-		m.BR.B(f.Name)
-		Func(m, f.Decl.Type, true)
-		m.br()
+		s = append(s, span{kind: sp_name, text: f.Name})
+		if tp := f.Decl.Type.TypeParams; tp != nil {
+			s = append(s, span{kind: sp_go, text: "["})
+			s = append(s, params(tp.List)...)
+			s = append(s, span{kind: sp_go, text: "]"})
+		}
+		s = append(s, Func(f.Decl.Type)...)
+		m.anchor(id)
+		m.line(s...)
 		if len(f.Doc) > 0 {
 			m.PP()
 			genDoc(m, f.Doc)
 		}
+		Examples(m, f.Examples)
 	}
 }
 
-func writer(m *F, br bool) func(string) {
-	if br {
-		return func(s string) { m.BR.B(s) }
+//recv_name is the name of the type of a receiver as go/doc gives it, like
+//*Set[T].
+func recv_name(recv string) string {
+	recv = strings.TrimPrefix(recv, "*")
+	if i := strings.Index(recv, "["); i != -1 {
+		recv = recv[:i]
 	}
-	return func(s string) { m.WriteString(s) }
+	return recv
 }
 
-func Func(m *F, f *ast.FuncType, decl bool) {
-	str := writer(m, decl)
-	str("(")
-	params(m, f.Params.List, decl)
-	str(")")
+//outrx matches the comment that gives the output of an example
+var outrx = RX("(?i)^[[:space:]]*(unordered )?output:")
+
+//Examples writes examples from the tests, with their code and, when they
+//say, what they output.
+func Examples(m *M, X []*doc.Example) {
+	for _, x := range X {
+		m.PP()
+		title := "Example"
+		if x.Suffix != "" {
+			title += " (" + x.Suffix + ")"
+		}
+		m.line(span{kind: sp_name, text: title})
+		if len(x.Doc) > 0 {
+			m.PP()
+			genDoc(m, x.Doc)
+		}
+
+		//the code without its output comment, or the braces of its body
+		var cs []*ast.CommentGroup
+		for _, c := range x.Comments {
+			if !outrx.MatchString(c.Text()) {
+				cs = append(cs, c)
+			}
+		}
+		var buf bytes.Buffer
+		err := printer.Fprint(&buf, m.fset, &printer.CommentedNode{Node: x.Code, Comments: cs})
+		if err != nil {
+			continue
+		}
+		src := buf.String()
+		if _, ok := x.Code.(*ast.BlockStmt); ok {
+			src = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(src, "{"), "}"))
+			src = strings.Replace(src, "\n\t", "\n", -1)
+		}
+		m.PP()
+		m.paras([]interface{}{code(src)})
+
+		if x.Output != "" {
+			m.PP()
+			if x.Unordered {
+				m.text([]byte("Unordered output:"))
+			} else {
+				m.text([]byte("Output:"))
+			}
+			m.PP()
+			m.paras([]interface{}{code(x.Output)})
+		}
+	}
+}
+
+//Func is the parameters and results of a function, the names of the
+//parameters set apart from their types.
+func Func(f *ast.FuncType) (s []span) {
+	s = append(s, span{kind: sp_go, text: "("})
+	s = append(s, params(f.Params.List)...)
+	s = append(s, span{kind: sp_go, text: ")"})
 	if r := f.Results; r != nil {
-		str(" ")
+		s = append(s, span{kind: sp_go, text: " "})
 		p := len(r.List) > 1 || len(r.List[0].Names) > 0
 		if p {
-			str("(")
+			s = append(s, span{kind: sp_go, text: "("})
 		}
-		params(m, r.List, decl)
+		s = append(s, params(r.List)...)
 		if p {
-			str(")")
+			s = append(s, span{kind: sp_go, text: ")"})
 		}
 	}
+	return s
 }
 
-func params(m *F, fl []*ast.Field, decl bool) {
-	if len(fl) == 0 {
-		return
-	}
-	str := writer(m, decl)
+func params(fl []*ast.Field) (s []span) {
 	for i, f := range fl {
 		for j, n := range f.Names {
-			if decl {
-				m.BR.R(n.Name)
-			} else {
-				str(n.Name)
-			}
+			s = append(s, span{kind: sp_param, text: n.Name})
 			if j != len(f.Names)-1 {
-				str(",")
+				s = append(s, span{kind: sp_go, text: ","})
 			}
-			str(" ")
+			s = append(s, span{kind: sp_go, text: " "})
 		}
-		str(typesigs(f.Type))
+		s = append(s, span{kind: sp_go, text: typesigs(f.Type)})
 		if i != len(fl)-1 {
-			str(", ")
+			s = append(s, span{kind: sp_go, text: ", "})
 		}
 	}
+	return s
 }
 
-func fields(mr *F, fl *ast.FieldList, sep string) (unex bool) {
+//plain_spans is the text of s without what sets its pieces apart.
+func plain_spans(s []span) string {
+	var out []string
+	for _, sp := range s {
+		out = append(out, sp.text)
+	}
+	return strings.Join(out, "")
+}
+
+//fields writes the fields of a struct a line each, leaving out unexported
+//ones, which it reports.
+func fields(m *M, fl *ast.FieldList) (unex bool) {
 	if fl == nil || len(fl.List) == 0 {
 		return
 	}
-	if sep != "\n" {
-		sep += " "
-	}
-	for i, f := range fl.List {
-		m := Formatter()
-		uxc := 0
-		for j, n := range f.Names {
-			if !ast.IsExported(n.Name) {
-				unex = true
-				uxc++
-				continue
-			}
-			m.WriteString(n.Name)
-			if j != len(f.Names)-1 {
-				m.WriteString(", ")
+	for _, f := range fl.List {
+		var names []string
+		for _, n := range f.Names {
+			if ast.IsExported(n.Name) {
+				names = append(names, n.Name)
 			} else {
-				m.WriteByte(' ')
+				unex = true
 			}
 		}
-		if uxc == len(f.Names) && uxc != 0 {
-			continue
-		} else if len(f.Names) == 0 {
-			var v ast.Expr
-			switch t := f.Type.(type) {
-			case *ast.Ident:
-				v = t
-			case *ast.SelectorExpr:
-				v = t.X
-			case *ast.StarExpr:
-				v = t.X
-			}
-			if !ast.IsExported(v.(*ast.Ident).Name) {
+		if len(f.Names) == 0 {
+			//embedded, so named for its type
+			if !ast.IsExported(embedded_name(f.Type)) {
+				unex = true
 				continue
 			}
+		} else if len(names) == 0 {
+			continue
 		}
-		sig := typesigs(f.Type)
-		if sep != "\n" {
-			m.BR.B(sig)
-		} else {
-			m.WriteString(sig)
-		}
-		if i != len(fl.List)-1 {
-			if sep == "\n" {
-				m.nl()
-				m.WriteString(".sp 0\n")
-			} else {
-				m.WriteString(sep)
-			}
-		}
-		if m.Len() > 0 {
-			if sep == "\n" {
-				mr.WriteString(".B ")
-			}
-			mr.Write(m.Bytes())
+		l := typesigs(f.Type)
+		if len(names) > 0 {
+			l = strings.Join(names, ", ") + " " + l
 		}
-
+		m.line(span{kind: sp_go, text: l})
 	}
 	return
 }
 
-func methods(m *F, fl *ast.FieldList, inline bool) (unex bool) {
+//embedded_name is the name of the field an embedded type gives a struct.
+func embedded_name(x ast.Expr) string {
+	for {
+		switch t := x.(type) {
+		case *ast.Ident:
+			return t.Name
+		case *ast.SelectorExpr:
+			return t.Sel.Name
+		case *ast.StarExpr:
+			x = t.X
+		case *ast.ParenExpr:
+			x = t.X
+		case *ast.IndexExpr:
+			x = t.X
+		case *ast.IndexListExpr:
+			x = t.X
+		default:
+			return ""
+		}
+	}
+}
+
+//methods writes the methods and embedded types or unions of an interface a
+//line each, leaving out unexported methods, which it reports.
+func methods(m *M, fl *ast.FieldList) (unex bool) {
 	if fl == nil || len(fl.List) == 0 {
 		return
 	}
 	for _, f := range fl.List {
-		if !inline {
-			m.nl()
-			m.WriteString(".B ")
+		if f.Names != nil && !ast.IsExported(f.Names[0].Name) {
+			unex = true
+			continue
 		}
 		if f.Names != nil {
 			name := f.Names[0].Name
-			if ast.IsExported(name) {
-				m.WriteString(name)
-				Func(m, f.Type.(*ast.FuncType), false)
-			} else {
-				unex = true
-			}
+			l := name + plain_spans(Func(f.Type.(*ast.FuncType)))
+			m.line(span{kind: sp_go, text: l})
 		} else {
-			m.WriteString(typesigs(f.Type))
-		}
-		if inline {
-			m.WriteString("; ")
-		} else {
-			m.nl()
-			m.WriteString(".sp 0\n")
+			m.line(span{kind: sp_go, text: typesigs(f.Type)})
 		}
 	}
 	return
 }
 
-func typesig(m *M, e interface{}) {
-	b := Formatter()
-	typesigi(b, e, false)
-	m.Write(b.Bytes())
-}
-
-func typesigs(e interface{}) string {
-	m := Formatter()
-	typesigi(m, e, true)
-	return string(m.Bytes())
+//tparams is the list of type parameters fl declares, as in
+//[K comparable, V any], or "" if there are none.
+func tparams(fl *ast.FieldList) string {
+	if fl == nil || len(fl.List) == 0 {
+		return ""
+	}
+	return "[" + plain_spans(params(fl.List)) + "]"
 }
 
-func typesigi(m *F, e interface{}, embedded bool) {
-	str := writer(m, !embedded)
-	switch t := e.(type) {
-	case *ast.ArrayType:
-		str("[")
-		if s, ok := t.Len.(*ast.Ident); ok {
-			str(s.Name)
-		}
-		str("]")
-		typesigi(m, t.Elt, embedded)
-	case *ast.ChanType:
-		if t.Dir == ast.RECV {
-			str("<-")
-		}
-		str("chan")
-		if t.Dir == ast.SEND {
-			str("<-")
-		}
-		str(" ")
-		typesigi(m, t.Value, embedded)
-	case *ast.Ellipsis:
-		str("...")
-		typesigi(m, t.Elt, embedded)
-	case *ast.MapType:
-		str("map[")
-		typesigi(m, t.Key, embedded)
-		str("]")
-		typesigi(m, t.Value, embedded)
-	case *ast.StarExpr:
-		str("*")
-		typesigi(m, t.X, embedded)
-	case *ast.Ident: //named type
-		str(t.Name)
-	case *ast.FuncType:
-		str("func")
-		Func(m, t, false)
-	case *ast.InterfaceType:
-		str("interface{")
-		methods(m, t.Methods, true)
-		str("}")
-	case *ast.StructType:
-		m.WriteString("struct{")
-		fields(m, t.Fields, ";")
-		str("}")
-	case *ast.SelectorExpr:
-		typesigi(m, t.X, embedded)
-		str(".")
-		typesigi(m, t.Sel, embedded)
+//typesigs is the type expression e as gofmt would write it, on one line.
+func typesigs(e ast.Expr) string {
+	var buf bytes.Buffer
+	//without the original positions, the printer puts everything it can
+	//on one line; only struct and interface types still take up more
+	if err := printer.Fprint(&buf, token.NewFileSet(), e); err != nil {
+		return ""
+	}
+	ls := strings.Split(buf.String(), "\n")
+	out := ls[0]
+	for i, l := range ls[1:] {
+		l = strings.TrimSpace(l)
+		if strings.HasSuffix(ls[i], "{") || strings.HasPrefix(l, "}") {
+			out += " " + l
+		} else {
+			out += "; " + l
+		}
 	}
+	return strings.Replace(out, "\t", " ", -1)
 }
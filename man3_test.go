@@ -0,0 +1,119 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"testing"
+)
+
+func TestTypesigs(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"[4]byte", "[4]byte"},
+		{"[N*2]int", "[N * 2]int"},
+		{"[N*2 + 1]T", "[N*2 + 1]T"},
+		{"[len(x) + 1]T", "[len(x) + 1]T"},
+		{"(int)", "(int)"},
+		{"*(T)", "*(T)"},
+		{"[](func() error)", "[](func() error)"},
+		{"pkg.T[int]", "pkg.T[int]"},
+		{"pkg.Map[string, []pkg.T[int]]", "pkg.Map[string, []pkg.T[int]]"},
+		{"map[pkg.K[int]]*pkg.V[string, bool]", "map[pkg.K[int]]*pkg.V[string, bool]"},
+		{"chan<- <-chan int", "chan<- <-chan int"},
+		{"func(...int) (n int, err error)", "func(...int) (n int, err error)"},
+		{"interface{ ~int | ~string }", "interface{ ~int | ~string }"},
+		{"struct{ A int; B string }", "struct { A int; B string }"},
+		{"struct{ pkg.Base[int]; *List[T] }", "struct { pkg.Base[int]; *List[T] }"},
+		{`struct{ A int "json:\"a\"" }`, `struct { A int "json:\"a\"" }`},
+	} {
+		x, err := parser.ParseExpr(c.src)
+		if err != nil {
+			t.Fatalf("%s: %v", c.src, err)
+		}
+		if got := typesigs(x); got != c.want {
+			t.Errorf("typesigs(%s) = %q, want %q", c.src, got, c.want)
+		}
+	}
+}
+
+//struct_of parses the struct type named S out of src.
+func struct_of(t *testing.T, src string) *ast.StructType {
+	f, err := parser.ParseFile(token.NewFileSet(), "s.go", "package p\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range f.Decls {
+		if g, ok := d.(*ast.GenDecl); ok && g.Tok == token.TYPE {
+			return g.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType)
+		}
+	}
+	t.Fatal("no struct in", src)
+	return nil
+}
+
+//written is the lines of the declarations written to m.
+func written(m *M) (out []string) {
+	for _, s := range m.pg.sections {
+		for _, b := range s.blocks {
+			if d, ok := b.(*declaration); ok {
+				out = append(out, plain_spans(d.line))
+			}
+		}
+	}
+	return out
+}
+
+func TestFields(t *testing.T) {
+	for _, c := range []struct {
+		src  string
+		want []string
+		unex bool
+	}{
+		{"type S struct { pkg.Base[int]; *List[K, V]; A, b [N*2]int }",
+			[]string{"pkg.Base[int]", "*List[K, V]", "A [N * 2]int"}, true},
+		{"type S struct { base[int]; *pkg.U[T] }",
+			[]string{"*pkg.U[T]"}, true},
+		{"type S[T any] struct { Next *S[T]; F func(T) (T, error) }",
+			[]string{"Next *S[T]", "F func(T) (T, error)"}, false},
+	} {
+		m := &M{tree: new_tree()}
+		unex := fields(m, struct_of(t, c.src).Fields)
+		got := written(m)
+		if len(got) != len(c.want) {
+			t.Errorf("%s: got %q, want %q", c.src, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("%s: line %d is %q, want %q", c.src, i, got[i], c.want[i])
+			}
+		}
+		if unex != c.unex {
+			t.Errorf("%s: unexported is %v, want %v", c.src, unex, c.unex)
+		}
+	}
+}
+
+//TestTypesigsTroff checks how types come out in man(7), where -, \, " and a
+//leading . mean something to troff.
+func TestTypesigsTroff(t *testing.T) {
+	for _, c := range []struct{ src, want string }{
+		{"[N-1]int", ".B \"[N \\- 1]int\"\n"},
+		{"chan<- int", ".B \"chan<\\- int\"\n"},
+		{"...int", ".B \\&...int\n"},
+		{`struct{ A int "a\\b" }`, ".B \"struct { A int \\(dqa\\e\\eb\\(dq }\"\n"},
+	} {
+		//as a parameter, where ... can be
+		x, err := parser.ParseExpr("func(_ " + c.src + ")")
+		if err != nil {
+			t.Fatalf("%s: %v", c.src, err)
+		}
+		typ := x.(*ast.FuncType).Params.List[0].Type
+		f := Formatter()
+		f.line(span{kind: sp_go, text: typesigs(typ)})
+		f.finish()
+		if got := string(f.Bytes()); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.src, got, c.want)
+		}
+	}
+}
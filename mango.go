@@ -11,19 +11,57 @@
 //The input is the comments and AST of the Go package.
 //The output is raw troff markup (compatiable with nroff and groff) dumped
 //to stdout for pipelining, see EXAMPLES.
+//With -format the same page is written as HTML, as Markdown for
+//README-style docs kept next to the man pages, or as mdoc(7) for the
+//semantic macros BSD systems and mandoc(1) prefer.
+//With -format text it is laid out as nroff(1) would, for when there is no
+//nroff to pipe it through; add -ansi to view it with less -R.
+//With -format json, what was extracted for the page is written as JSON for
+//other programs: its sections, the flags with their placeholders, defaults
+//and help, the Go declarations, the bugs and the references.
 //
 //For section 1 man pages, Mango bases the OPTIONS section on the use of flag(3)
 //and a special comment. It takes
 //	var file = flag.String("source", "", "Set source file")
 //	var toggle = flag.Bool("t", false, "Toggle mode")
-//	var banner = flag.Bool("title", "Hello", "Set banner")
+//	var banner = flag.String("title", "Hello", "Set banner")
 //and produces:
 //	-source file
-//	-toggle
-//	-banner = Hello
-//Extraction of this information will not work if the flag package is renamed
-//on import and it can only extract flags defined with flag.Type and not those
-//defined with flag.TypeVar in the init() function.
+//	-t
+//	-title banner = Hello
+//Flags defined with flag.TypeVar, or assigned from flag.Type, in init(),
+//main() or any function they call are found as well; the variable bound to
+//the flag names its argument.
+//Flags defined with flag.Var or flag.TextVar are named after the type of the
+//value instead, and a flag.Var shows a default when the String method of its
+//value returns a literal, or the value was initialized with one.
+//flag.Func and flag.BoolFunc are understood as well.
+//Flags bound to the same variable, like
+//	flag.BoolVar(&verbose, "v", false, "")
+//	flag.BoolVar(&verbose, "verbose", false, "Be verbose")
+//are one option with several names, listed together as -v, -verbose with the
+//longest help any of them has, as the others often only say "The same as -v".
+//
+//Commands of the form "name subcommand [flags]", where each subcommand has
+//its own flag.FlagSet, get a synopsis line per subcommand and a COMMANDS
+//section listing each subcommand and its options.
+//A subcommand is named by the first argument to flag.NewFlagSet and described
+//by the comment on the function that calls it, or on the variable it is
+//assigned to, which may have its own Usage line.
+//Flags defined on a FlagSet passed to another function are found as well.
+//With -subcommands, each subcommand gets its own page instead, named
+//name-subcommand, in section 1, as git(1) does.
+//
+//Commands built with github.com/spf13/cobra or github.com/urfave/cli are read
+//the same way, without running them: the Use, Short and Long fields of each
+//cobra.Command and the flags defined on it with pflag, or the Name, Usage,
+//ArgsUsage, Description, Flags and Commands of a cli.App or cli.Command, fill
+//in the SYNOPSIS, OPTIONS and COMMANDS sections.
+//Shorthands, like those of pflag's StringP, and aliases are listed with the
+//option they stand for.
+//If the package has no comment, the description of the root command is used.
+//Package flag is recognized by its import path, so it may be renamed or dot
+//imported.
 //Additional information may be given with a comment like
 //	//Usage: %name %flags [optional-arg] required-arg
 //The "Usage:" part is mandatory.
@@ -43,23 +81,50 @@
 //Format package in current directory as a pdf:
 //	mango | groff -man | ps2pdf - name.section.pdf
 //
-//Format ogle(3), and ogle(1), which exist in the same directory:
-//	mango $GOROOT/src/pkg/exp/ogle | nroff -man > ogle.3
-//	mango -package main $GOROOT/src/pkg/exp/ogle | nroff -man > ogle.1
+//Format a command in a subdirectory of the current module:
+//	mango ./cmd/foo | nroff -man > foo.1
+//
+//Format a library by its import path:
+//	mango example.com/our/lib | nroff -man > lib.3
+//
+//Format a single file as if it were a package:
+//	mango hello.go | nroff -man > hello.1
+//
+//Write a page for every command and library in the current module into man/,
+//as name.1 and name.3 files:
+//	mango -o man ./...
+//
+//Install compressed pages for them where man(1) finds them, as
+//man1/name.1.gz and man3/name.3.gz:
+//	mango -install $PREFIX/share/man -gzip ./...
 //
-//Format your package in a makefile:
-//	mango -import $TARG $GOFILES | nroff -man > name.section
+//Fail, showing what changed, if the pages in man/ are out of date:
+//	mango -check man ./...
+//
+//Report, as file:line, comments that leave out what a page needs, like a
+//package comment, a short first sentence for NAME, the help of a flag or the
+//comment of an exported identifier, misspelled section headings, and pages
+//in SEE ALSO that are not installed:
+//	mango -lint ./...
 //
 //FORMATTING
 //
-//An all caps word on a line by itself, followed and preceded by a blank like
-//denotes a new section.
+//Comments are read as go/doc/comment reads them, so the rules are those of
+//gofmt'd doc comments.
+//A # Heading line, or an all caps word on a line by itself, followed and
+//preceded by a blank like denotes a new section.
+//Within the comment of a declaration, a heading starts a subsection instead.
 //
 //Two consecutive newlines start a new paragraph.
 //
 //Indented paragraphs, with one tab or four spaces, maintain their relative
 //indentation (a uniform, initial indent is ignored) and no additional
-//formatting is applied
+//formatting is applied, unless they are lists.
+//Lines starting with -, *, + or a number and a dot are list items when they
+//are indented, or when they end a paragraph.
+//
+//Links like [text] with a [text]: URL line, and URLs, become hyperlinks.
+//Doc links like [Name] are shown as their name.
 //
 //Words beginning with a hyphen are assumed to be command line switches, and
 //they, including the hypen, are bolded.
@@ -69,26 +134,44 @@
 //
 //HEURISTICS
 //
-//If no directory or files are specified, Mango uses the current working
+//The arguments are package patterns, as understood by go(1): a directory,
+//an import path, or a list of .go files.
+//If no arguments are given, Mango uses the package in the current working
 //directory.
-//If there are multiple packages in the input directory, any package named
-//'documentation' is used as the package level documentation.
-//If there is no 'documentation' package or there are still multiple packages
-//after taking 'documentation' out of play, Mango will use the package with the
-//same name as the input directory.
-//If this is incorrect, or you wish to generate man pages for multiple packages
-//in one directory you can select them individually with the -package flag.
+//Packages are resolved by the go command, so go.mod, the module path and build
+//constraints are honored exactly as they are when building.
+//If a pattern matches more than one package, select one with the -package
+//flag, which takes a package name or import path, or use -o to write a page
+//for each of them.
+//With -o, packages whose pages would have the same file name are reported and
+//only the first is written.
 //
 //The name of the man page is the name of the package for section 3 man pages
-//and the name of the file that contains func main() for section 1 man pages.
+//and the name go install gives the command for section 1 man pages, or the
+//name of the file that contains func main() if there is no import path.
 //This can be overridden with the -name flag, but only for section 1 pages.
 //
-//For man 3 pages, the import path defaults to the name of the package.
-//It can be overridden with the -import flag and the
+//For man 3 pages, the import path is the one the go command reports for the
+//package, or the name of the package if it has none.
+//It can be overridden with the -import flag.
+//
+//Examples in the package's _test.go files are shown after what they are
+//examples of, with what they output, and those of the package itself in an
+//EXAMPLES section.
+//
+//The date of a page is the one given with the -date flag, or the one in
+//$SOURCE_DATE_EPOCH, or else the date of the last git commit to the files of
+//the package, so making a page twice makes the same page.
+//Only outside of git is it today's date.
 //
 //If the -version flag is not used, Mango searches the AST for a const or var
-//declaration named Version.
-//Failing that, it uses today's date as the version.
+//declaration named Version or version, whose value may be another constant.
+//A var set to a placeholder like "dev", for go build -ldflags -X to set, is
+//skipped unless it is set with -X, as in:
+//	mango -X main.version=v1.2.0 ./cmd/foo
+//Failing that, it uses the version of the module, which for the module being
+//worked on is its latest tag as git describe reports it.
+//Failing that, it uses the date of the page as the version.
 //
 //If the -manual flag is not used, it defaults to "User Commands" for man 1
 //pages and to "Go Packages" for man 3 pages, respectively.
@@ -103,16 +186,20 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"go/ast"
 	"go/doc"
-	"go/parser"
+	"go/doc/comment"
 	"go/token"
 	"io/ioutil"
 	"log"
 	"os"
-	"path"
+	"strconv"
 	"strings"
+	"time"
+
+	"golang.org/x/tools/go/packages"
 )
 
 var (
@@ -123,10 +210,39 @@ var (
 		"Specify name of man page")
 	version = flag.String("version", "",
 		"Specify version")
+	page_date = flag.String("date", "",
+		"Date the pages, as 2006-01-02")
 	manual = flag.String("manual", "",
 		"Specify the manual: see man-pages(7)")
 	package_name = flag.String("package", "",
-		"Select package to use if there are multiple packages in a directory")
+		"Select package, by name or import path, if the arguments match several")
+	outdir = flag.String("o", "",
+		`Write a name.section file for every package matched into the given directory,
+instead of writing a single page to stdout.`)
+	mandirs = flag.Bool("mandirs", false,
+		"With -o, write each page into the manN subdirectory for its section, where man(1) looks for it")
+	compress = flag.Bool("gzip", false,
+		"With -o or -install, compress each page, as name.section.gz")
+	install = flag.String("install", "",
+		`Install a page for every package matched into the manN subdirectories of the
+given directory, like $PREFIX/share/man. The same as -o dir -mandirs.`)
+	check_path = flag.String("check", "",
+		`Instead of writing pages, compare them with the pages in the given file, or
+in the given directory as -o would write them there, and exit with status 1
+and a diff of each that differs other than in the date.`)
+	lint_mode = flag.Bool("lint", false,
+		`Instead of writing pages, report where the comments of the packages matched
+leave something out of their pages or break the rules they are read by, and
+exit with status 1 if they do.`)
+	format_name = flag.String("format", "man",
+		"Write pages in one of these formats: html, json, man, markdown, mdoc, text")
+	width = flag.Int("width", 80,
+		"With -format text, the number of columns to fill lines to")
+	ansi = flag.Bool("ansi", false,
+		"With -format text, set what man pages set in bold or italic in bold or underlined, for a terminal")
+	subpages = flag.Bool("subcommands", false,
+		`Also write a command-subcommand.1 page for each subcommand of a command.
+Requires -o.`)
 	Sections = flag.String("section", "",
 		`Generate sections from a comma-seperated list of filenames. Each section will
 be named after the file name that contains it (_ will be replaced by a space).
@@ -139,8 +255,17 @@ You cannot override SYNOPSIS, OPTIONS, BUGS or SEE ALSO.`)
 be named after the file name that contains it (_ will be replaced by a space).
 The contents of each file will be included as-is. To let mango do the formatting
 use -section.`)
+
+	ldx = ldflags{}
 )
 
+//the flags that cannot be defined with an expression
+func init() {
+	flag.StringVar(outdir, "outdir", "", "The same as -o")
+	flag.Var(ldx, "X", `Set the variable importpath.name to value, given as importpath.name=value,
+as go build -ldflags -X does, for the version. This may be repeated.`)
+}
+
 func stderr(s interface{}) {
 	log.Println(s)
 }
@@ -149,18 +274,18 @@ func fatal(msg interface{}) {
 	log.Fatalln(msg)
 }
 
-func invalid_flag(s, nm string, flag *string) {
-	if *flag != "" {
+func invalid_flag(s, nm string, flag string) {
+	if flag != "" {
 		fatal("The " + nm + " flag does not apply to section " + s + " pages.")
 	}
 }
 
-func lspkgs(dir string, pkgs map[string]*ast.Package) {
-	stderr(dir + " contains the following packages:")
-	for k := range pkgs {
-		stderr("\t" + k)
+func lspkgs(pkgs []*packages.Package) {
+	stderr("The arguments match the following packages:")
+	for _, p := range pkgs {
+		stderr("\t" + p.Name + "\t" + p.PkgPath)
 	}
-	stderr("Don't know how to handle a directory with multiple packages")
+	stderr("Don't know how to handle more than one package")
 	fatal("Specify one of the above with -package")
 }
 
@@ -168,46 +293,26 @@ func usage(err interface{}) {
 	if err != nil {
 		stderr(err)
 	}
-	stderr("mango [flags] [package-directory|package-files]\nflags:")
+	stderr("mango [flags] [packages]\nflags:")
 	flag.PrintDefaults()
 	os.Exit(1)
 }
 
-var suff = strings.HasSuffix
-var pref = strings.HasPrefix
-
-func filter(fi os.FileInfo) bool {
-	notdir := !fi.IsDir()
-	n := fi.Name()
-	gofile := suff(n, ".go") && !suff(n, "_test.go")
-	return notdir && gofile
-}
-
-func ParseFiles(fset *token.FileSet, files []string, mode parser.Mode) (pkgs map[string]*ast.Package, first error) {
-	for _, file := range files {
-		if src, err := parser.ParseFile(fset, file, nil, mode); err != nil {
-			nm := src.Name.Name
-			pkg, found := pkgs[nm]
-			if !found {
-				pkg = &ast.Package{
-					Name:  nm,
-					Files: map[string]*ast.File{},
-				}
-				pkgs[nm] = pkg
-			}
-			pkg.Files[file] = src
-		} else {
-			return nil, err
+//choose picks the package to document out of those the arguments matched.
+func choose(pkgs []*packages.Package) *packages.Package {
+	switch len(pkgs) {
+	case 1:
+		if *package_name == "" {
+			return pkgs[0]
 		}
 	}
-	return
-}
-
-func clean(pwd, p string) string {
-	if !path.IsAbs(p) {
-		p = path.Clean(path.Join(pwd, p))
+	for _, p := range pkgs {
+		if p.Name == *package_name || p.PkgPath == *package_name {
+			return p
+		}
 	}
-	return path.Clean(p)
+	lspkgs(pkgs)
+	return nil
 }
 
 type pair struct {
@@ -238,117 +343,180 @@ func csv_files(in string, disallow bool) (out []*pair) {
 	return out
 }
 
-//Usage: %name %flags [package-directory|package-files]
-func main() {
-	log.SetFlags(0)
-	flag.Parse()
+//ldflags are the variables -X sets, by importpath.name.
+type ldflags map[string]string
 
-	if *help {
-		usage(nil)
-	}
+func (l ldflags) String() string {
+	return ""
+}
 
-	pwd, err := os.Getwd()
-	if err != nil {
-		fatal(err)
+func (l ldflags) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i < 1 {
+		return errors.New("not of the form importpath.name=value")
 	}
+	l[s[:i]] = s[i+1:]
+	return nil
+}
 
-	var pkg *ast.Package
-	var pkgs map[string]*ast.Package
+//options are the settings a page is generated with. They are filled in from
+//the command line once, so generating many pages does not touch the flags.
+type options struct {
+	name, version, manual, import_path string
+	format                             string
+	width                              int
+	ansi                               bool
+	mandirs, gzip                      bool
+	date                               string //when the pages are dated, if not by git
+	ldx                                map[string]string
+	overd                              []*section
+	subpages                           bool
+}
 
-	//Select and parse files
-	dir := pwd
-	var files []string
-	if flag.NArg() == 1 {
-		//one arg we assume is a directory name
-		dir = clean(pwd, flag.Arg(0))
-		//but it could be a package contained in one .go file
-		if suff(dir, ".go") {
-			files = []string{dir}
-		}
-	} else if flag.NArg() > 1 {
-		//more than one args we assume is multiple files so we can do
-		//mango -import $TARG $GOFILES
-		//in make files
-		files = flag.Args()
-		for i, file := range files {
-			files[i] = clean(pwd, file)
-		}
+func flag_options() *options {
+	o := &options{
+		name:        *name,
+		version:     *version,
+		manual:      *manual,
+		import_path: *import_path,
+		subpages:    *subpages,
+		format:      *format_name,
+		width:       *width,
+		ansi:        *ansi,
+		mandirs:     *mandirs,
+		gzip:        *compress,
+		date:        *page_date,
+		ldx:         ldx,
 	}
-	//parse package(s)
-	fs := token.NewFileSet()
-	if len(files) > 0 {
-		pkgs, err = ParseFiles(fs, files, parser.ParseComments)
-	} else {
-		pkgs, err = parser.ParseDir(fs, dir, filter, parser.ParseComments)
+	if o.date == "" {
+		o.date = source_date()
+	} else if _, err := time.Parse("2006-01-02", o.date); err != nil {
+		fatal("The date " + o.date + " is not of the form 2006-01-02.")
 	}
-	if err != nil {
-		fatal("Could not parse " + dir + "\n" + err.Error())
+	if _, ok := formats[o.format]; !ok {
+		fatal("Unknown format " + o.format + ", the formats are " + format_names() + ".")
 	}
-
-	//Check for a documentation package
-	var xdoc string
-	if d, ok := pkgs["documentation"]; ok {
-		xdoc = doc.New(d, "", 0).Doc
-		delete(pkgs, "documentation")
-	}
-
-	//Select package
-	switch len(pkgs) {
-	case 0:
-		fatal("No packages found at " + dir)
-	case 1:
-		// what we want
-	default:
-		if *package_name != "" {
-			var ok bool
-			pkg, ok = pkgs[*package_name]
-			if !ok {
-				lspkgs(dir, pkgs)
-			}
-		} else {
-			name := path.Base(dir)
-			if p, ok := pkgs[name]; ok {
-				pkg = p
-			} else {
-				lspkgs(dir, pkgs)
-			}
-		}
-	}
-	if pkg == nil {
-		//hack because we don't know or care what the package is
-		for _, v := range pkgs {
-			pkg = v
-		}
-	}
-
-	var overd []*section
 	if *Sections != "" {
 		for _, pair := range csv_files(*Sections, true) {
-			overd = append(overd, &section{pair.key, unstring(pair.value)})
+			o.overd = append(o.overd, &section{pair.key, unstring(&comment.Parser{}, pair.value)})
 		}
 	}
 	if *Includes != "" {
 		for _, pair := range csv_files(*Includes, false) {
-			overd = append(overd, &section{pair.key, []interface{}{pair.value}})
+			o.overd = append(o.overd, &section{pair.key, []interface{}{pair.value}})
 		}
 	}
+	return o
+}
 
-	//Build and dump docs
-	docs := doc.New(pkg, "", doc.AllDecls|doc.AllMethods)
-	//hack around there being a documentation package, part 2
-	if xdoc != "" {
-		docs.Doc = xdoc
+//source_date is the date in $SOURCE_DATE_EPOCH, the seconds since 1970 that
+//reproducible builds set the time to, or "" if it is not set.
+func source_date() string {
+	e := os.Getenv("SOURCE_DATE_EPOCH")
+	if e == "" {
+		return ""
+	}
+	secs, err := strconv.ParseInt(e, 10, 64)
+	if err != nil {
+		fatal("SOURCE_DATE_EPOCH is not a number of seconds: " + e)
+	}
+	return time.Unix(secs, 0).UTC().Format("2006-01-02")
+}
+
+//page builds the man page for one loaded package.
+func page(fs *token.FileSet, p *packages.Package, o *options) *M {
+	pkg := ast_package(fs, p)
+	//the test files are only there for their examples
+	files := append(append([]*ast.File{}, p.Syntax...), test_files(fs, p)...)
+	docs, err := doc.NewFromFiles(fs, files, import_of(p), doc.AllDecls|doc.AllMethods|doc.PreserveAST)
+	if err != nil {
+		fatal(err)
+	}
+	m := NewManPage(pkg, docs, docs.Parser(), o)
+	m.fset, m.types, m.info = fs, p.Types, p.TypesInfo
+	if m.version == "" {
+		m.version = grep_version(m)
+	}
+	if m.version == "" {
+		m.version = module_version(p)
 	}
-	m := NewManPage(pkg, docs, overd)
 
 	if pkg.Name == "main" {
-		invalid_flag("1", "import", import_path)
+		invalid_flag("1", "import", o.import_path)
 		doCommand(m)
 	} else {
-		invalid_flag("3", "name", name)
+		invalid_flag("3", "name", o.name)
 		doPackage(m)
 	}
 
-	m.nl()
-	os.Stdout.Write(m.Bytes())
+	m.finish()
+	return m
+}
+
+//Usage: %name %flags [packages]
+func main() {
+	log.SetFlags(0)
+	flag.Parse()
+
+	if *help {
+		usage(nil)
+	}
+
+	//Resolve and parse packages
+	fs := token.NewFileSet()
+	pkgs, err := load(fs, flag.Args())
+	if err != nil {
+		fatal("Could not load packages\n" + err.Error())
+	}
+	if len(pkgs) == 0 {
+		fatal("No packages found")
+	}
+	if *install != "" {
+		if *outdir != "" {
+			fatal("Use either -o or -install.")
+		}
+		*outdir, *mandirs = *install, true
+	}
+	many := *outdir != "" || *lint_mode
+	if *lint_mode && (*outdir != "" || *check_path != "") {
+		fatal("Use -lint without -o or -check.")
+	}
+	if *check_path != "" {
+		if *outdir != "" {
+			fatal("Use either -check or -o.")
+		}
+		//like -o when checking a directory
+		fi, err := os.Stat(*check_path)
+		many = err == nil && fi.IsDir()
+	}
+	if !many || *package_name != "" {
+		pkgs = []*packages.Package{choose(pkgs)}
+	}
+	o := flag_options()
+	if !many {
+		switch {
+		case o.subpages:
+			fatal("The subcommands flag requires -o.")
+		case o.mandirs:
+			fatal("The mandirs flag requires -o.")
+		case o.gzip:
+			fatal("The gzip flag requires -o or -install.")
+		}
+	}
+
+	//Build and dump docs
+	if *lint_mode {
+		lint(fs, pkgs, o)
+		return
+	}
+	if *check_path != "" {
+		o.date = check_date
+		check(fs, pkgs, o, *check_path)
+		return
+	}
+	if *outdir != "" {
+		batch(fs, pkgs, o, *outdir)
+		return
+	}
+	os.Stdout.Write(page(fs, pkgs[0], o).Bytes())
 }
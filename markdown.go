@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+)
+
+//D writes CommonMark, laid out like a man page.
+type D struct {
+	*bytes.Buffer
+	in     []*md_block //the indents and the bodies of tags left open
+	lines  bool        //the last thing written was a line
+	tight  bool        //no blank line before the next block
+	first  string      //what the next block starts with instead of the prefix
+	next   string      //anchors for the next thing written
+	footer string
+}
+
+//md_block is something the text that follows is indented within.
+type md_block struct {
+	pre string //what each line of the text starts with
+	pad int    //how many steps a line is indented by
+	tag bool   //the body of a tag
+}
+
+func Markdown() *D {
+	return &D{Buffer: &bytes.Buffer{}}
+}
+
+//md_escape escapes what would be read as markup in text.
+func md_escape(s string) string {
+	var out strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("\\`*_[]<>&", r) {
+			out.WriteByte('\\')
+		}
+		out.WriteRune(r)
+	}
+	return out.String()
+}
+
+var md_startrx = RX("^([#+=>-]|[0-9]+[.)])")
+
+//md_start escapes what would make the first word of a block anything but
+//text, like a # heading or the number of a list item.
+func md_start(word string) string {
+	if loc := md_startrx.FindStringIndex(word); loc != nil {
+		return word[:loc[1]-1] + "\\" + word[loc[1]-1:]
+	}
+	return word
+}
+
+//md_code makes a code span of s, with enough backticks around it to hold
+//those in it.
+func md_code(s string) string {
+	n, run := 0, 0
+	for _, r := range s {
+		if r == '`' {
+			run++
+			if run > n {
+				n = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	ticks := strings.Repeat("`", n+1)
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+	return ticks + s + ticks
+}
+
+//md_wrap puts mark around s, leaving the spaces at either end outside, as
+//emphasis can't start or end with one.
+func md_wrap(s, mark string) string {
+	t := strings.TrimLeft(s, " ")
+	lead := s[:len(s)-len(t)]
+	u := strings.TrimRight(t, " ")
+	if u == "" {
+		return s
+	}
+	return lead + mark + u + mark + t[len(u):]
+}
+
+func (m *D) top() *md_block {
+	if len(m.in) == 0 {
+		return &md_block{}
+	}
+	return m.in[len(m.in)-1]
+}
+
+func (m *D) pop() *md_block {
+	last := m.top()
+	if len(m.in) > 0 {
+		m.in = m.in[:len(m.in)-1]
+	}
+	return last
+}
+
+//start begins a block, after a blank line unless it is in a tight list.
+func (m *D) start() {
+	if m.Len() > 0 {
+		if m.Bytes()[m.Len()-1] != '\n' {
+			m.WriteByte('\n')
+		}
+		if !m.tight && !bytes.HasSuffix(m.Bytes(), []byte("\n\n")) {
+			m.WriteByte('\n')
+		}
+	}
+	m.tight = false
+	m.lines = false
+	if m.first != "" {
+		m.WriteString(m.first)
+		m.first = ""
+	} else {
+		m.WriteString(m.top().pre)
+	}
+	m.anchors()
+}
+
+//anchors writes the anchors waiting for the next thing written.
+func (m *D) anchors() {
+	m.WriteString(m.next)
+	m.next = ""
+}
+
+func (m *D) begin(name, sec, date, version, manual string) {
+	m.WriteString("<!-- Automatically generated by mango(1) -->\n\n")
+	m.WriteString("# " + md_escape(name+"("+sec+")") + "\n\n")
+	m.WriteString(md_escape(manual) + "\n")
+	m.footer = "version " + md_escape(version) + ", " + md_escape(date)
+}
+
+func (m *D) finish() {
+	m.in = nil
+	m.start()
+	m.WriteString("---\n\n" + m.footer + "\n")
+}
+
+func (m *D) name_line(name string, descr []byte) {
+	m.section("NAME")
+	m.start()
+	m.WriteString(md_escape(name))
+	if len(descr) > 0 {
+		m.WriteString(" — " + md_escape(string(descr)))
+	}
+	m.WriteByte('\n')
+}
+
+func (m *D) section(name string) {
+	m.in = nil
+	m.start()
+	m.WriteString("## " + md_escape(strings.TrimSpace(name)) + "\n")
+}
+
+func (m *D) subsection(name string) {
+	m.in = nil
+	m.start()
+	m.WriteString("### " + md_escape(strings.TrimSpace(name)) + "\n")
+}
+
+func (m *D) anchor(id string) {
+	//raw HTML, which CommonMark lets through, so the links have somewhere to go
+	m.next += `<a id="` + id + `"></a>`
+}
+
+func (m *D) PP() {
+	//like .PP, end a tagged paragraph
+	if m.top().tag {
+		m.pop()
+	}
+}
+
+func (m *D) indent() {
+	t := m.top()
+	if t.tag {
+		//within a list item it is already indented
+		m.in = append(m.in, &md_block{pre: t.pre, pad: t.pad})
+		return
+	}
+	m.in = append(m.in, &md_block{pre: t.pre, pad: t.pad + 1})
+}
+
+func (m *D) outdent() {
+	for len(m.in) > 0 && m.pop().tag {
+	}
+}
+
+func (m *D) line(s ...span) {
+	if m.lines {
+		//a hard line break
+		m.Truncate(m.Len() - 1)
+		m.WriteString("\\\n" + m.top().pre)
+		m.anchors()
+	} else {
+		m.start()
+	}
+	m.WriteString(strings.Repeat("&emsp;", m.top().pad))
+	m.spans(s)
+	m.WriteByte('\n')
+	m.lines = true
+}
+
+func (m *D) tag(s ...span) {
+	if m.top().tag {
+		m.pop()
+	}
+	m.start()
+	m.WriteString("- ")
+	m.spans(s)
+	m.WriteByte('\n')
+	t := m.top()
+	m.in = append(m.in, &md_block{pre: t.pre + "  ", tag: true})
+}
+
+//spans writes a line of Go as a code span, and anything else as text.
+func (m *D) spans(s []span) {
+	code, links := false, false
+	for _, sp := range s {
+		code = code || sp.kind == sp_go || sp.kind == sp_param
+		links = links || sp.link != ""
+	}
+	if code && !links {
+		var t strings.Builder
+		for _, sp := range s {
+			t.WriteString(sp.text)
+		}
+		m.WriteString(md_code(t.String()))
+		return
+	}
+	for _, sp := range s {
+		t := md_escape(sp.text)
+		switch sp.kind {
+		case sp_name, sp_flag:
+			t = md_wrap(t, "**")
+		case sp_arg, sp_param:
+			t = md_wrap(t, "*")
+		case sp_go:
+			t = md_code(sp.text)
+		case sp_ref:
+			t = md_ref(sp.text)
+		}
+		if sp.link != "" {
+			t = "[" + t + "](#" + sp.link + ")"
+		}
+		m.WriteString(t)
+	}
+}
+
+//md_ref links a reference like ls(1) to the page -o would write for it.
+func md_ref(ref string) string {
+	name, sec := split_ref(ref)
+	return "[**" + md_escape(name) + "**(" + md_escape(sec) + ")](" +
+		name + "." + sec + ".md)"
+}
+
+func (m *D) see_also(refs []string) {
+	m.section("SEE ALSO")
+	m.start()
+	for i, r := range refs {
+		if i != 0 {
+			m.WriteString(",\n")
+		}
+		m.WriteString(md_ref(r))
+	}
+	m.WriteByte('\n')
+}
+
+func (m *D) text(p []byte) {
+	m.start()
+	m.words(p)
+	m.WriteByte('\n')
+}
+
+func (m *D) words(p []byte) {
+	for i, word := range strings.Fields(string(p)) {
+		if i != 0 {
+			m.WriteByte(' ')
+		}
+		switch {
+		case switchrx.MatchString(word):
+			m.WriteString("**" + md_escape(word) + "**")
+		case urlrx.MatchString(word):
+			sub := urlrx.FindStringSubmatch(word)
+			m.WriteString("<" + sub[1] + ">" + md_escape(sub[2]))
+		case refrx.MatchString(word):
+			m.WriteString(md_ref(word))
+		case i == 0:
+			m.WriteString(md_start(md_escape(word)))
+		default:
+			m.WriteString(md_escape(word))
+		}
+	}
+}
+
+func (m *D) paras(ps []interface{}) {
+	for _, P := range ps {
+		m.para(P)
+	}
+}
+
+//fence writes lines as a fenced code block.
+func (m *D) fence(lines []string) {
+	ticks := "```"
+	for _, l := range lines {
+		for strings.Contains(l, ticks) {
+			ticks += "`"
+		}
+	}
+	m.start()
+	m.WriteString(ticks + "\n")
+	for _, l := range lines {
+		m.WriteString(m.top().pre + l + "\n")
+	}
+	m.WriteString(m.top().pre + ticks + "\n")
+}
+
+func (m *D) para(P interface{}) {
+	switch p := P.(type) {
+	case []byte: //raw troff from -include, as is
+		m.fence(strings.Split(strings.TrimRight(string(p), "\n"), "\n"))
+	case [][]byte:
+		m.text(bytes.Join(p, []byte(" ")))
+	case heading:
+		m.start()
+		m.WriteString("### " + md_escape(strings.TrimSpace(string(p))) + "\n")
+	case *list:
+		t := m.top()
+		for i, it := range p.items {
+			marker := "- "
+			if it.number != "" {
+				marker = it.number + ". "
+			}
+			m.tight = i != 0 && !p.loose
+			m.first = t.pre + marker
+			m.in = append(m.in, &md_block{pre: t.pre + strings.Repeat(" ", len(marker))})
+			m.paras(it.paras)
+			m.pop()
+		}
+	case []*loc:
+		var lines []string
+		for _, l := range p {
+			line := strings.TrimRight(string(l.line), "\n")
+			if l.indent > 0 {
+				line = strings.Repeat("    ", l.indent-1) + line
+			}
+			lines = append(lines, line)
+		}
+		m.fence(lines)
+	}
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+//C writes mdoc(7), the semantic macros of BSD man pages.
+type C struct {
+	*bytes.Buffer
+	sec   string
+	open  []string //what closes the lists and displays left open, innermost last
+	lines bool     //the last thing written was a line
+	fresh bool     //nothing has been written since a heading or the start of a list
+}
+
+func Mdoc() *C {
+	return &C{Buffer: &bytes.Buffer{}}
+}
+
+func (m *C) nl() {
+	if m.Len() > 0 && m.Bytes()[m.Len()-1] != '\n' {
+		m.WriteByte('\n')
+	}
+}
+
+//macro writes a line with a macro on it.
+func (m *C) macro(s string) {
+	m.nl()
+	m.WriteString("." + s + "\n")
+	m.lines = false
+	m.fresh = false
+}
+
+//macrorx matches what mdoc could take for a macro or a delimiter
+var macrorx = RX(`^([A-Z][A-Za-z]{1,2}|[][().,:;!?|])$`)
+
+//arg escapes s as an argument of a macro, so it is neither read as a macro
+//nor as punctuation mdoc moves around.
+func arg(s string) string {
+	e := string(escape([]byte(s)))
+	if macrorx.MatchString(e) {
+		e = "\\&" + e
+	}
+	if strings.ContainsAny(e, " \t") {
+		e = "\"" + e + "\""
+	}
+	return e
+}
+
+func (m *C) begin(name, sec, date, version, manual string) {
+	m.sec = sec
+	if t, err := time.Parse("2006-01-02", date); err == nil {
+		date = t.Format("January 2, 2006")
+	}
+	//mdoc names the manual after the section on its own
+	m.WriteString(".\\\"    Automatically generated by mango(1)\n")
+	m.macro("Dd " + date)
+	m.macro("Dt " + arg(strings.ToUpper(name)) + " " + sec)
+	m.macro("Os " + arg("version "+version))
+}
+
+func (m *C) finish() {
+	m.close(0)
+	m.nl()
+}
+
+//close closes all but the first n lists and displays.
+func (m *C) close(n int) {
+	for len(m.open) > n {
+		m.pop()
+	}
+}
+
+func (m *C) push(open, close string) {
+	if open != "" {
+		m.macro(open)
+	}
+	m.open = append(m.open, close)
+	m.fresh = true
+}
+
+func (m *C) pop() string {
+	last := m.open[len(m.open)-1]
+	m.open = m.open[:len(m.open)-1]
+	if last != "" {
+		m.macro(last)
+	}
+	return last
+}
+
+func (m *C) top() string {
+	if len(m.open) == 0 {
+		return ""
+	}
+	return m.open[len(m.open)-1]
+}
+
+func (m *C) name_line(name string, descr []byte) {
+	m.section("NAME")
+	m.macro("Nm " + arg(name))
+	if len(descr) > 0 {
+		m.macro("Nd " + string(escape(descr)))
+	}
+}
+
+func (m *C) section(name string) {
+	m.close(0)
+	m.macro("Sh " + string(escape([]byte(strings.TrimSpace(name)))))
+	m.fresh = true
+}
+
+func (m *C) subsection(name string) {
+	m.close(0)
+	m.macro("Ss " + string(escape([]byte(strings.TrimSpace(name)))))
+	m.fresh = true
+}
+
+func (m *C) anchor(id string) {}
+
+func (m *C) PP() {
+	if m.top() == "El" {
+		m.pop()
+	}
+	//a paragraph right after a heading or a list item is only a warning
+	if !m.fresh {
+		m.macro("Pp")
+	}
+	m.fresh = true
+}
+
+func (m *C) indent() {
+	if m.top() == "El" {
+		//an item is already indented
+		m.push("", "")
+		return
+	}
+	m.push("Bd -ragged -offset indent", "Ed")
+}
+
+func (m *C) outdent() {
+	for len(m.open) > 0 {
+		if c := m.pop(); c == "Ed" || c == "" {
+			return
+		}
+	}
+}
+
+func (m *C) line(s ...span) {
+	if m.lines {
+		m.macro("br")
+	}
+	if f, ok := m.fn(s); ok {
+		m.nl()
+		m.WriteString(f)
+	} else {
+		m.macro(m.callable(s, true))
+	}
+	m.lines = true
+	m.fresh = false
+}
+
+func (m *C) tag(s ...span) {
+	if m.top() != "El" {
+		m.push("Bl -tag -width Ds", "El")
+	}
+	m.macro("It " + m.callable(s, false))
+	m.fresh = true
+}
+
+//callable writes s as the arguments of macros on one line, like
+//Nm tool Oo Fl v Oc Ar file, with Ns where nothing is between them.
+//A line of a synopsis starts with the command.
+func (m *C) callable(s []span, synopsis bool) string {
+	var out []string
+	gap, after := true, "" //a space before the next word, the last macro
+	word := func(mac, w string) {
+		switch {
+		case mac == "No" && w == "[":
+			mac, w = "Oo", ""
+		case mac == "No" && w == "]":
+			mac, w, gap = "Oc", "", true
+		case mac == "No" && w == "|":
+			//a delimiter mdoc sets apart with spaces
+			mac, w, gap = "", "|", true
+		}
+		if !gap && after != "Oo" {
+			out = append(out, "Ns")
+		}
+		if mac != "" {
+			out = append(out, mac)
+		}
+		if w != "" {
+			if mac == "" {
+				out = append(out, w)
+			} else {
+				out = append(out, arg(w))
+			}
+		}
+		gap, after = false, mac
+	}
+	words := func(mac, t string) {
+		for i, w := range strings.Split(t, " ") {
+			if i != 0 {
+				gap = true
+			}
+			if w != "" {
+				word(mac, w)
+			}
+		}
+	}
+	for i, sp := range s {
+		switch sp.kind {
+		case sp_name:
+			if m.sec == "1" && synopsis && i == 0 {
+				//the command, then its subcommands
+				ws := strings.Fields(sp.text)
+				if len(ws) == 0 {
+					continue
+				}
+				word("Nm", ws[0])
+				words("Cm", " "+strings.Join(ws[1:], " "))
+			} else if m.sec == "1" {
+				words("Cm", sp.text)
+			} else {
+				words("Sy", sp.text)
+			}
+		case sp_flag:
+			words("Fl", strings.TrimPrefix(sp.text, "-"))
+		case sp_arg:
+			words("Ar", sp.text)
+		case sp_param:
+			words("Fa", sp.text)
+		case sp_go:
+			word("Li", sp.text)
+		case sp_ref:
+			name, sec := split_ref(sp.text)
+			out = append(out, "Xr", arg(name), sec)
+			gap, after = false, "Xr"
+		default:
+			words("No", sp.text)
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+//fn writes the declaration of a function in s with Ft for its results and
+//Fn for its name and parameters, as in .Ft error and .Fn Get "k string",
+//after a line with func and the receiver, if s is one.
+func (m *C) fn(s []span) (string, bool) {
+	if len(s) == 0 || s[0].kind != sp_go || s[0].text != "func " {
+		return "", false
+	}
+	i := 0
+	var ft, name string
+	for ; i < len(s) && s[i].kind != sp_name; i++ {
+		ft += s[i].text
+	}
+	if i == len(s) {
+		return "", false
+	}
+	name = s[i].text
+	i++
+	if i < len(s) && s[i].text == "[" {
+		for ; i < len(s) && s[i].text != "]"; i++ {
+			name += s[i].text
+		}
+		name += "]"
+		i++
+	}
+	if i == len(s) || s[i].text != "(" {
+		return "", false
+	}
+	var params []string
+	p := ""
+	for i++; i < len(s) && s[i].text != ")"; i++ {
+		if s[i].text == ", " {
+			params = append(params, p)
+			p = ""
+			continue
+		}
+		p += s[i].text
+	}
+	if p != "" {
+		params = append(params, p)
+	}
+	var results string
+	for i++; i < len(s); i++ {
+		results += s[i].text
+	}
+
+	out := "\\&" + string(escape([]byte(strings.TrimSpace(ft)))) + "\n"
+	if r := strings.TrimSpace(results); r != "" {
+		out += ".Ft " + arg(r) + "\n"
+	}
+	out += ".Fn " + arg(name)
+	for _, p := range params {
+		out += " " + arg(p)
+	}
+	return out + "\n", true
+}
+
+func (m *C) see_also(refs []string) {
+	m.section("SEE ALSO")
+	for i, r := range refs {
+		name, sec := split_ref(r)
+		x := "Xr " + arg(name) + " " + sec
+		if i != len(refs)-1 {
+			x += " ,"
+		}
+		m.macro(x)
+	}
+}
+
+func (m *C) words(sentence []byte) {
+	for _, word := range strings.Fields(string(sentence)) {
+		switch {
+		case switchrx.MatchString(word):
+			m.macro("Fl " + arg(strings.TrimPrefix(word, "-")))
+		case urlrx.MatchString(word):
+			sub := urlrx.FindStringSubmatch(word)
+			l := "Lk " + sub[1]
+			for _, p := range sub[2] {
+				l += " " + string(p)
+			}
+			m.macro(l)
+		case refrx.MatchString(word):
+			name, sec := split_ref(word)
+			m.macro("Xr " + arg(name) + " " + sec)
+		default:
+			if m.Len() > 0 && m.Bytes()[m.Len()-1] != '\n' {
+				m.WriteByte(' ')
+			}
+			m.Write(escape([]byte(word)))
+		}
+	}
+	m.fresh = false
+}
+
+func (m *C) text(p []byte) {
+	for _, s := range sentences(p) {
+		m.nl()
+		m.words(s)
+	}
+	m.lines = false
+}
+
+func (m *C) paras(ps []interface{}) {
+	for i, P := range ps {
+		if i != 0 {
+			m.macro("Pp")
+		}
+		m.para(P)
+	}
+	m.lines = false
+}
+
+func (m *C) para(P interface{}) {
+	switch p := P.(type) {
+	case []byte: //raw troff from -include, as is
+		m.nl()
+		m.Write(p)
+		m.nl()
+	case [][]byte:
+		for _, s := range p {
+			m.nl()
+			m.words(s)
+		}
+	case heading: //only within a section, so a subsection
+		m.macro("Ss " + string(escape([]byte(strings.TrimSpace(string(p))))))
+	case *list:
+		bl := "Bl -bullet"
+		if len(p.items) > 0 && p.items[0].number != "" {
+			bl = "Bl -enum"
+		}
+		if !p.loose {
+			bl += " -compact"
+		}
+		m.macro(bl)
+		for _, it := range p.items {
+			m.macro("It")
+			for j, ip := range it.paras {
+				if j != 0 {
+					m.macro("Pp")
+				}
+				m.para(ip)
+			}
+		}
+		m.macro("El")
+	case []*loc:
+		m.macro("Bd -literal -offset indent")
+		for _, l := range p {
+			line := bytes.TrimRight(l.line, "\n")
+			if l.indent > 0 {
+				m.WriteString(strings.Repeat("    ", l.indent-1))
+			}
+			m.Write(escape(line))
+			m.WriteByte('\n')
+		}
+		m.macro("Ed")
+	}
+	m.fresh = false
+}
@@ -9,6 +9,12 @@ const NS = "[^ \t]"
 
 var refrx = RX("..\\(.\\)$") //used in extract.go:words
 
+//urlrx matches a URL as a word, maybe in <>, and what punctuation follows it
+var urlrx = RX("^<?([a-z]+://[^ \t<>]+?)>?([.,;:!?)]*)$")
+
+//linkrx matches the URL plain puts after the text of a link
+var linkrx = RX(" <[a-z]+://[^ \t<>]+>")
+
 func inverseMatch(r *regexp.Regexp, s []byte) [][]byte {
 	in := r.FindAllIndex(s, -1)
 	ln, sln := len(in), len(s)
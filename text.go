@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+//P writes plain text laid out as nroff(1) would lay out the page, so it can
+//be read without it. With ansi, what troff sets in bold or italic is set in
+//bold or underlined with ANSI escapes, for a terminal or less -R.
+type P struct {
+	*bytes.Buffer
+	width  int
+	ansi   bool
+	in     []*txt_block //the indents and the bodies of tags left open
+	sep    bool         //a blank line goes before the next thing written
+	fresh  bool         //nothing has been written since a heading
+	tagged *txt_word    //a tag waiting to see if what it is about fits beside it
+	tag_at int          //the column of the tag
+	footer [3]string
+}
+
+//txt_block is something the text that follows is indented within.
+type txt_block struct {
+	indent int
+	tag    bool //the body of a tag
+}
+
+//a txt_word is what is written for a word, and how wide it shows.
+type txt_word struct {
+	s string
+	n int
+}
+
+//txt_step is how far a section, or a tag, indents, as .RS and .TP do.
+const txt_step = 7
+
+func Plain(width int, ansi bool) *P {
+	return &P{Buffer: &bytes.Buffer{}, width: width, ansi: ansi}
+}
+
+//style sets s in the font troff sets a kind of span in.
+func (m *P) style(kind int, s string) string {
+	if !m.ansi || s == "" {
+		return s
+	}
+	switch font(kind) {
+	case 'B':
+		return "\x1b[1m" + s + "\x1b[0m"
+	case 'I':
+		return "\x1b[4m" + s + "\x1b[0m"
+	}
+	return s
+}
+
+func (m *P) cur() int {
+	if len(m.in) == 0 {
+		return txt_step
+	}
+	return m.in[len(m.in)-1].indent
+}
+
+func (m *P) top() *txt_block {
+	if len(m.in) == 0 {
+		return &txt_block{indent: txt_step}
+	}
+	return m.in[len(m.in)-1]
+}
+
+func (m *P) pop() *txt_block {
+	last := m.top()
+	if len(m.in) > 0 {
+		m.in = m.in[:len(m.in)-1]
+	}
+	return last
+}
+
+//blank writes the blank line that goes before what is written next, if any.
+func (m *P) blank() {
+	if m.sep && !m.fresh && m.Len() > 0 {
+		m.WriteByte('\n')
+	}
+	m.sep, m.fresh = false, false
+}
+
+//flush writes the tag on a line of its own, if it is still waiting.
+func (m *P) flush() {
+	if m.tagged == nil {
+		return
+	}
+	m.WriteString(strings.Repeat(" ", m.tag_at) + m.tagged.s + "\n")
+	m.tagged = nil
+}
+
+//wrap writes words from column ind to the width, with the lines after the
+//first hung by hang more.
+func (m *P) wrap(ws []*txt_word, ind, hang int) {
+	if t := m.tagged; t != nil && m.tag_at+t.n+1 <= ind {
+		m.WriteString(strings.Repeat(" ", m.tag_at) + t.s + strings.Repeat(" ", ind-m.tag_at-t.n))
+		m.tagged = nil
+	} else {
+		m.flush()
+		m.blank()
+		m.WriteString(strings.Repeat(" ", ind))
+	}
+	col, start := ind, ind
+	for i, w := range ws {
+		if i != 0 {
+			if col+1+w.n > m.width && col > start {
+				start = ind + hang
+				m.WriteString("\n" + strings.Repeat(" ", start))
+				col = start
+			} else {
+				m.WriteByte(' ')
+				col++
+			}
+		}
+		m.WriteString(w.s)
+		col += w.n
+	}
+	m.WriteByte('\n')
+}
+
+//spread writes three things at the left, middle and right of a line, like
+//the header and footer nroff writes.
+func (m *P) spread(l, c, r string) {
+	n := utf8.RuneCountInString
+	gap := m.width - n(l) - n(c) - n(r)
+	left := gap / 2
+	if left < 1 {
+		left, gap = 1, 2
+	}
+	m.WriteString(l + strings.Repeat(" ", left) + c + strings.Repeat(" ", gap-left) + r + "\n")
+}
+
+func (m *P) begin(name, sec, date, version, manual string) {
+	title := strings.ToUpper(name) + "(" + sec + ")"
+	m.spread(title, manual, title)
+	m.footer = [3]string{"version " + version, date, title}
+}
+
+func (m *P) finish() {
+	m.flush()
+	m.sep = true
+	m.blank()
+	m.spread(m.footer[0], m.footer[1], m.footer[2])
+}
+
+func (m *P) name_line(name string, descr []byte) {
+	m.section("NAME")
+	ws := []*txt_word{{name, utf8.RuneCountInString(name)}}
+	if len(descr) > 0 {
+		ws = append(ws, &txt_word{"-", 1})
+		ws = append(ws, m.words(descr)...)
+	}
+	m.wrap(ws, txt_step, 0)
+}
+
+func (m *P) heading(name string, ind int) {
+	m.flush()
+	m.sep = true
+	m.blank()
+	m.WriteString(strings.Repeat(" ", ind) + m.style(sp_name, strings.TrimSpace(name)) + "\n")
+	m.fresh = true
+}
+
+func (m *P) section(name string) {
+	m.in = nil
+	m.heading(name, 0)
+}
+
+func (m *P) subsection(name string) {
+	m.in = nil
+	m.heading(name, 3)
+}
+
+func (m *P) anchor(id string) {}
+
+func (m *P) PP() {
+	m.flush()
+	if m.top().tag {
+		m.pop()
+	}
+	m.sep = true
+}
+
+func (m *P) indent() {
+	m.flush()
+	t := m.top()
+	if t.tag {
+		//what a tag is about is already indented
+		m.in = append(m.in, &txt_block{indent: t.indent})
+		return
+	}
+	m.in = append(m.in, &txt_block{indent: t.indent + txt_step})
+}
+
+func (m *P) outdent() {
+	m.flush()
+	for len(m.in) > 0 && m.pop().tag {
+	}
+}
+
+func (m *P) line(s ...span) {
+	m.wrap(m.spans(s), m.cur(), 4)
+}
+
+func (m *P) tag(s ...span) {
+	m.flush()
+	if m.top().tag {
+		m.pop()
+	}
+	m.sep = true
+	m.blank()
+	var t txt_word
+	for i, w := range m.spans(s) {
+		if i != 0 {
+			t.s += " "
+			t.n++
+		}
+		t.s += w.s
+		t.n += w.n
+	}
+	m.tagged, m.tag_at = &t, m.cur()
+	m.in = append(m.in, &txt_block{indent: m.cur() + txt_step, tag: true})
+}
+
+//spans breaks s into words where there are spaces, each styled piece by
+//piece.
+func (m *P) spans(s []span) (ws []*txt_word) {
+	gap := true
+	add := func(kind int, t string) {
+		for i, w := range strings.Split(t, " ") {
+			if i != 0 {
+				gap = true
+			}
+			if w == "" {
+				continue
+			}
+			if gap || len(ws) == 0 {
+				ws = append(ws, &txt_word{})
+			}
+			l := ws[len(ws)-1]
+			l.s += m.style(kind, w)
+			l.n += utf8.RuneCountInString(w)
+			gap = false
+		}
+	}
+	for _, sp := range s {
+		if sp.kind == sp_ref {
+			name, sec := split_ref(sp.text)
+			add(sp_name, name)
+			add(sp_text, "("+sec+")")
+			continue
+		}
+		add(sp.kind, sp.text)
+	}
+	return ws
+}
+
+func (m *P) see_also(refs []string) {
+	m.section("SEE ALSO")
+	var ws []*txt_word
+	for i, r := range refs {
+		s := []span{{kind: sp_ref, text: r}}
+		if i != len(refs)-1 {
+			s = append(s, span{kind: sp_text, text: ","})
+		}
+		ws = append(ws, m.spans(s)...)
+	}
+	m.wrap(ws, m.cur(), 0)
+}
+
+func (m *P) words(p []byte) (ws []*txt_word) {
+	for _, word := range strings.Fields(string(p)) {
+		var s []span
+		switch {
+		case switchrx.MatchString(word):
+			s = []span{{kind: sp_flag, text: word}}
+		case urlrx.MatchString(word):
+			sub := urlrx.FindStringSubmatch(word)
+			s = []span{{kind: sp_text, text: sub[1] + sub[2]}}
+		case refrx.MatchString(word):
+			s = []span{{kind: sp_ref, text: word}}
+		default:
+			s = []span{{kind: sp_text, text: word}}
+		}
+		ws = append(ws, m.spans(s)...)
+	}
+	return ws
+}
+
+func (m *P) text(p []byte) {
+	m.wrap(m.words(p), m.cur(), 0)
+}
+
+func (m *P) paras(ps []interface{}) {
+	for i, P := range ps {
+		if i != 0 {
+			m.PP()
+		}
+		m.para(P)
+	}
+}
+
+//verbatim writes lines as they are, from column ind.
+func (m *P) verbatim(lines []string, ind int) {
+	m.flush()
+	m.blank()
+	for _, l := range lines {
+		if l == "" {
+			m.WriteByte('\n')
+			continue
+		}
+		m.WriteString(strings.Repeat(" ", ind) + l + "\n")
+	}
+}
+
+func (m *P) para(P interface{}) {
+	switch p := P.(type) {
+	case []byte: //raw troff from -include, as is
+		m.verbatim(strings.Split(strings.TrimRight(string(p), "\n"), "\n"), m.cur())
+	case [][]byte:
+		m.text(bytes.Join(p, []byte(" ")))
+	case heading:
+		m.heading(string(p), 3)
+	case *list:
+		//hang the items as far in as the widest number needs
+		w := 2
+		for _, it := range p.items {
+			if len(it.number)+2 > w {
+				w = len(it.number) + 2
+			}
+		}
+		for i, it := range p.items {
+			m.flush()
+			if i != 0 && p.loose {
+				m.sep = true
+			}
+			m.blank()
+			if it.number != "" {
+				m.tagged = &txt_word{it.number + ".", len(it.number) + 1}
+			} else {
+				m.tagged = &txt_word{"•", 1}
+			}
+			m.tag_at = m.cur()
+			m.in = append(m.in, &txt_block{indent: m.cur() + w})
+			m.paras(it.paras)
+			m.pop()
+		}
+	case []*loc:
+		var lines []string
+		for _, l := range p {
+			line := strings.TrimRight(string(l.line), "\n")
+			if l.indent > 0 {
+				line = strings.Repeat("    ", l.indent-1) + line
+			}
+			lines = append(lines, line)
+		}
+		m.verbatim(lines, m.cur()+4)
+	}
+}
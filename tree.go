@@ -0,0 +1,268 @@
+package main
+
+//The tree is what doCommand and doPackage extract for a page, in the order
+//it goes there. They build it by saying what goes on the page, as they would
+//to a backend, and render then has a backend write it, so a backend, or
+//anything else that reads the tree, needs no part of how it was extracted.
+
+//man_page is a man page, with what its header and NAME section say.
+type man_page struct {
+	name, sec, date, version, manual string
+	descr                            []byte //the short description in NAME
+	sections                         []*page_section
+}
+
+//body holds the blocks of a section or of what it nests: a brk, a prose,
+//a paras, an anchor, a *declaration, a *tag_list, an *indented, or, in a
+//section, a *subsection.
+type body struct {
+	blocks []interface{}
+}
+
+func (b *body) get() *body {
+	return b
+}
+
+//a holder is something with a body.
+type holder interface {
+	get() *body
+}
+
+type page_section struct {
+	name string
+	refs []string //the cross references, when this is SEE ALSO
+	body
+}
+
+type subsection struct {
+	name string
+	body
+}
+
+//tag_list is a list of options, commands or anything else that is tagged
+//with what it is about, one after the other with nothing in between.
+type tag_list struct {
+	items []*tag_item
+}
+
+type tag_item struct {
+	ids  []string
+	term []span
+	opt  *opt //the flag the item is about, if it is one
+	body
+}
+
+type indented struct {
+	body
+}
+
+//declaration is one line of a synopsis or of a Go declaration, with the
+//identifiers declared on it.
+type declaration struct {
+	ids  []string
+	line []span
+}
+
+//brk is a paragraph break, which also ends a tag_item.
+type brk struct{}
+
+//prose is a paragraph of text, like the help of a flag.
+type prose []byte
+
+//paras are paragraphs as extract.go splits them, like a section of a comment.
+type paras []interface{}
+
+//anchor marks where an identifier is declared, when what is declared is not
+//on a declaration.
+type anchor string
+
+//tree builds a page from what goes on it, in order.
+type tree struct {
+	pg   *man_page
+	open []holder //innermost last
+	ids  []string //anchors for the next block
+}
+
+func new_tree() *tree {
+	return &tree{pg: &man_page{}}
+}
+
+func (t *tree) top() holder {
+	if len(t.open) == 0 {
+		//something before the first section
+		t.section("")
+	}
+	return t.open[len(t.open)-1]
+}
+
+func (t *tree) pop() holder {
+	last := t.top()
+	t.open = t.open[:len(t.open)-1]
+	return last
+}
+
+func (t *tree) add(x interface{}) {
+	b := t.top().get()
+	if d, ok := x.(*declaration); ok {
+		d.ids, t.ids = t.ids, nil
+	}
+	for _, id := range t.ids {
+		b.blocks = append(b.blocks, anchor(id))
+	}
+	t.ids = nil
+	b.blocks = append(b.blocks, x)
+}
+
+func (t *tree) begin(name, sec, date, version, manual string) {
+	p := t.pg
+	p.name, p.sec, p.date, p.version, p.manual = name, sec, date, version, manual
+}
+
+func (t *tree) name_line(name string, descr []byte) {
+	t.pg.name, t.pg.descr = name, descr
+}
+
+func (t *tree) section(name string) {
+	s := &page_section{name: name}
+	t.pg.sections = append(t.pg.sections, s)
+	t.open = []holder{s}
+}
+
+func (t *tree) subsection(name string) {
+	t.top()
+	t.open = t.open[:1]
+	s := &subsection{name: name}
+	t.add(s)
+	t.open = append(t.open, s)
+}
+
+func (t *tree) anchor(id string) {
+	t.ids = append(t.ids, id)
+}
+
+func (t *tree) PP() {
+	if _, ok := t.top().(*tag_item); ok {
+		t.pop()
+	}
+	t.add(brk{})
+}
+
+func (t *tree) text(p []byte) {
+	t.add(prose(p))
+}
+
+func (t *tree) paras(ps []interface{}) {
+	t.add(paras(ps))
+}
+
+func (t *tree) line(s ...span) {
+	t.add(&declaration{line: s})
+}
+
+func (t *tree) tag(s ...span) {
+	if _, ok := t.top().(*tag_item); ok {
+		t.pop()
+	}
+	it := &tag_item{ids: t.ids, term: s}
+	t.ids = nil
+	b := t.top().get()
+	if l := len(b.blocks); l > 0 {
+		if tl, ok := b.blocks[l-1].(*tag_list); ok {
+			tl.items = append(tl.items, it)
+			t.open = append(t.open, it)
+			return
+		}
+	}
+	t.add(&tag_list{items: []*tag_item{it}})
+	t.open = append(t.open, it)
+}
+
+//option tags what follows with flag o.
+func (t *tree) option(o *opt, s ...span) {
+	t.tag(s...)
+	t.top().(*tag_item).opt = o
+}
+
+func (t *tree) indent() {
+	in := &indented{}
+	t.add(in)
+	t.open = append(t.open, in)
+}
+
+func (t *tree) outdent() {
+	for len(t.open) > 1 {
+		if _, ok := t.pop().(*indented); ok {
+			return
+		}
+	}
+}
+
+func (t *tree) see_also(refs []string) {
+	t.section("SEE ALSO")
+	t.open[0].(*page_section).refs = refs
+}
+
+//writer writes a whole page from its tree.
+type writer interface {
+	write(p *man_page)
+	Bytes() []byte
+}
+
+//rendered writes a page with a backend.
+type rendered struct {
+	backend
+}
+
+func (r rendered) write(p *man_page) {
+	render(p, r.backend)
+}
+
+//render has b write the page p.
+func render(p *man_page, b backend) {
+	b.begin(p.name, p.sec, p.date, p.version, p.manual)
+	b.name_line(p.name, p.descr)
+	for _, s := range p.sections {
+		if s.refs != nil {
+			b.see_also(s.refs)
+			continue
+		}
+		b.section(s.name)
+		render_blocks(s.blocks, b)
+	}
+	b.finish()
+}
+
+func render_blocks(bs []interface{}, b backend) {
+	for _, x := range bs {
+		switch x := x.(type) {
+		case brk:
+			b.PP()
+		case prose:
+			b.text(x)
+		case paras:
+			b.paras(x)
+		case anchor:
+			b.anchor(string(x))
+		case *declaration:
+			for _, id := range x.ids {
+				b.anchor(id)
+			}
+			b.line(x.line...)
+		case *tag_list:
+			for _, it := range x.items {
+				for _, id := range it.ids {
+					b.anchor(id)
+				}
+				b.tag(it.term...)
+				render_blocks(it.blocks, b)
+			}
+		case *indented:
+			b.indent()
+			render_blocks(x.blocks, b)
+			b.outdent()
+		case *subsection:
+			b.subsection(x.name)
+			render_blocks(x.blocks, b)
+		}
+	}
+}
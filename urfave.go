@@ -0,0 +1,177 @@
+package main
+
+import (
+	"go/ast"
+	"sort"
+	"strings"
+)
+
+//cli_paths are the import paths of the major versions of urfave/cli, the
+//first of which gives its usual name.
+var cli_paths = []string{
+	"github.com/urfave/cli",
+	"github.com/urfave/cli/v2",
+	"github.com/urfave/cli/v3",
+}
+
+//grep_cli is the extractor for github.com/urfave/cli. It reads the cli.App
+//literal, or in v3 the cli.Command no other command lists, with its flags
+//and the commands under it.
+func grep_cli(m *M, fl *flags) (descrs []string) {
+	//the literals may be spread over files, so take every name for cli
+	r := &pkg_refs{names: map[string]bool{}}
+	for _, file := range files(m.pkg) {
+		fr := grep_refs(file, cli_paths...)
+		for n := range fr.names {
+			r.names[n] = true
+		}
+		r.dot = r.dot || fr.dot
+	}
+	if r.none() {
+		return nil
+	}
+
+	var apps, cmds []*ast.CompositeLit
+	for _, file := range files(m.pkg) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			if cl, ok := n.(*ast.CompositeLit); ok {
+				switch t, _ := r.is(cl.Type); t {
+				case "App":
+					apps = append(apps, cl)
+				case "Command":
+					cmds = append(cmds, cl)
+				}
+			}
+			return true
+		})
+	}
+
+	d := grep_defs(m.pkg, m.info)
+	//the composite literals in the list x, following names
+	list := func(x ast.Expr) (out []*ast.CompositeLit) {
+		if l := d.composite(x); l != nil {
+			for _, e := range l.Elts {
+				if cl := d.composite(e); cl != nil {
+					out = append(out, cl)
+				}
+			}
+		}
+		return out
+	}
+	kids := func(cl *ast.CompositeLit) []*ast.CompositeLit {
+		if x := field(cl, "Subcommands"); x != nil {
+			return list(x)
+		}
+		return list(field(cl, "Commands"))
+	}
+	opts := func(cl *ast.CompositeLit) (out []*opt) {
+		for _, f := range list(field(cl, "Flags")) {
+			if o := cli_opt(d, r, f); o != nil {
+				out = append(out, o)
+				descrs = append(descrs, string(o.help))
+			}
+		}
+		return out
+	}
+
+	var root *ast.CompositeLit
+	if len(apps) > 0 {
+		root = apps[0]
+	} else {
+		listed := map[*ast.CompositeLit]bool{}
+		for _, c := range cmds {
+			for _, k := range kids(c) {
+				listed[k] = true
+			}
+		}
+		for _, c := range cmds {
+			if !listed[c] {
+				root = c
+				break
+			}
+		}
+	}
+	if root == nil {
+		return nil
+	}
+
+	if fl.usage == "" {
+		fl.usage = d.str(field(root, "ArgsUsage"))
+	}
+	fl.flags = append(fl.flags, opts(root)...)
+	short, long := d.str(field(root, "Usage")), d.str(field(root, "Description"))
+	m.fallback_doc(short, long)
+	descrs = append(descrs, long)
+
+	var walk func(prefix string, cl *ast.CompositeLit)
+	walk = func(prefix string, cl *ast.CompositeLit) {
+		for _, k := range kids(cl) {
+			name := d.str(field(k, "Name"))
+			if name == "" || d.truth(field(k, "Hidden")) {
+				continue
+			}
+			name = strings.TrimSpace(prefix + " " + name)
+			doc := d.str(field(k, "Usage"))
+			if long := d.str(field(k, "Description")); long != "" {
+				doc += "\n\n" + long
+			}
+			fl.cmds = append(fl.cmds, &subcmd{
+				name:  name,
+				doc:   doc,
+				usage: d.str(field(k, "ArgsUsage")),
+				flags: opts(k),
+			})
+			descrs = append(descrs, doc)
+			walk(name, k)
+		}
+	}
+	walk("", root)
+	return descrs
+}
+
+//cli_opt is the option a cli.TypeFlag literal defines, or nil if cl is
+//not one.
+func cli_opt(d *defs, r *pkg_refs, cl *ast.CompositeLit) *opt {
+	t, _ := r.is(cl.Type)
+	typ := strings.TrimSuffix(t, "Flag")
+	if typ == t {
+		return nil
+	}
+	//v1 gives aliases after the name, as in "port, p"
+	names := strings.Split(d.str(field(cl, "Name")), ",")
+	if a := d.composite(field(cl, "Aliases")); a != nil {
+		for _, e := range a.Elts {
+			names = append(names, d.str(e))
+		}
+	}
+	o := &opt{
+		name:   []byte(strings.TrimSpace(names[0])),
+		help:   []byte(d.str(field(cl, "Usage"))),
+		nohelp: d.blank(field(cl, "Usage")),
+		dashes: "--",
+		pos:    cl.Pos(),
+	}
+	if len(o.name) == 0 {
+		return nil
+	} else if len(o.name) == 1 {
+		o.dashes = "-"
+	}
+	for _, n := range names[1:] {
+		//cli takes one dash for one letter names
+		if n = strings.TrimSpace(n); len(n) == 1 {
+			o.aka = append(o.aka, "-"+n)
+		} else if n != "" {
+			o.aka = append(o.aka, "--"+n)
+		}
+	}
+	sort.SliceStable(o.aka, func(i, j int) bool {
+		return len(o.aka[i]) < len(o.aka[j])
+	})
+	if typ != "Bool" {
+		o.varname = []byte(placeholder(typ))
+		if def := d.str(field(cl, "Value")); def != "" {
+			o.def = []byte(def)
+		}
+	}
+	return o
+}
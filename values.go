@@ -0,0 +1,180 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+)
+
+//defs is what each variable or constant was last assigned anywhere in a
+//package, at package level or in a function, and what each function returns.
+//Names are told apart by what go/types says they refer to, so a cmd in one
+//function is not the cmd in another. That is good enough to follow a command
+//or a list of flags defined in one place and used in another.
+type defs struct {
+	info *types.Info
+	vals map[types.Object]ast.Expr
+	rets map[types.Object]ast.Expr //first result of a function's first return
+}
+
+func grep_defs(pkg *ast.Package, info *types.Info) *defs {
+	d := &defs{info, map[types.Object]ast.Expr{}, map[types.Object]ast.Expr{}}
+	set := func(id *ast.Ident, x ast.Expr) {
+		if o := d.obj(id); o != nil {
+			d.vals[o] = x
+		}
+	}
+	for _, file := range files(pkg) {
+		ast.Inspect(file, func(n ast.Node) bool {
+			switch x := n.(type) {
+			case *ast.ValueSpec:
+				if len(x.Values) == len(x.Names) {
+					for i, n := range x.Names {
+						set(n, x.Values[i])
+					}
+				}
+			case *ast.AssignStmt:
+				if len(x.Lhs) == len(x.Rhs) {
+					for i, l := range x.Lhs {
+						if id, ok := l.(*ast.Ident); ok {
+							set(id, x.Rhs[i])
+						}
+					}
+				}
+			case *ast.FuncDecl:
+				f := d.obj(x.Name)
+				if x.Recv != nil || x.Body == nil || f == nil {
+					break
+				}
+				ast.Inspect(x.Body, func(n ast.Node) bool {
+					if _, ok := d.rets[f]; ok {
+						return false
+					}
+					switch r := n.(type) {
+					case *ast.FuncLit:
+						return false //its returns are its own
+					case *ast.ReturnStmt:
+						if len(r.Results) > 0 {
+							d.rets[f] = r.Results[0]
+						}
+					}
+					return true
+				})
+			}
+			return true
+		})
+	}
+	return d
+}
+
+//obj is what id declares or refers to, or nil if the package did not type
+//check that far.
+func (d *defs) obj(id *ast.Ident) types.Object {
+	if d.info == nil {
+		return nil
+	}
+	if o := d.info.Defs[id]; o != nil {
+		return o
+	}
+	return d.info.Uses[id]
+}
+
+//resolve follows x through names and calls of functions to what it was
+//defined as, giving up after a few steps in case of cycles.
+func (d *defs) resolve(x ast.Expr) ast.Expr {
+	for i := 0; i < 8; i++ {
+		var v ast.Expr
+		switch t := x.(type) {
+		case *ast.Ident:
+			if o := d.obj(t); o != nil {
+				v = d.vals[o]
+			}
+		case *ast.ParenExpr:
+			v = t.X
+		case *ast.CallExpr:
+			if id, ok := t.Fun.(*ast.Ident); ok {
+				if o := d.obj(id); o != nil {
+					v = d.rets[o]
+				}
+			}
+		case *ast.IndexExpr: //an element of a list literal
+			if l := d.composite(t.X); l != nil {
+				i, err := strconv.Atoi(d.str(t.Index))
+				if err == nil && i >= 0 && i < len(l.Elts) {
+					v = l.Elts[i]
+				}
+			}
+		}
+		if v == nil || v == x {
+			break
+		}
+		x = v
+	}
+	return x
+}
+
+//str is the value of a constant expression, following names, or "" if it
+//is not one. Strings are unquoted and concatenations are joined.
+func (d *defs) str(x ast.Expr) string {
+	if x == nil {
+		return ""
+	}
+	switch t := d.resolve(x).(type) {
+	case *ast.BasicLit:
+		return string(lit(t))
+	case *ast.BinaryExpr:
+		if t.Op == token.ADD {
+			return d.str(t.X) + d.str(t.Y)
+		}
+	}
+	return ""
+}
+
+//blank is whether x is left out or, following names, a string with only
+//spaces in it.
+func (d *defs) blank(x ast.Expr) bool {
+	if x == nil {
+		return true
+	}
+	x = d.resolve(x)
+	if b, ok := x.(*ast.BinaryExpr); ok && b.Op == token.ADD {
+		return d.blank(b.X) && d.blank(b.Y)
+	}
+	return blank(x)
+}
+
+//composite is the composite literal x is, or points to, following names.
+func (d *defs) composite(x ast.Expr) *ast.CompositeLit {
+	if x == nil {
+		return nil
+	}
+	x = d.resolve(x)
+	if u, ok := x.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		x = u.X
+	}
+	cl, _ := x.(*ast.CompositeLit)
+	return cl
+}
+
+//field is the value of the field called name in the composite literal cl,
+//or nil if it is not set by name.
+func field(cl *ast.CompositeLit, name string) ast.Expr {
+	for _, e := range cl.Elts {
+		if kv, ok := e.(*ast.KeyValueExpr); ok {
+			if id, ok := kv.Key.(*ast.Ident); ok && id.Name == name {
+				return kv.Value
+			}
+		}
+	}
+	return nil
+}
+
+//truth reports whether x is the constant true, following names.
+func (d *defs) truth(x ast.Expr) bool {
+	if x == nil {
+		return false
+	}
+	id, ok := d.resolve(x).(*ast.Ident)
+	return ok && id.Name == "true"
+}